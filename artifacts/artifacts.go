@@ -0,0 +1,139 @@
+// Package artifacts keeps everything a single test run produces in a directory of its own,
+// so that runs do not overwrite each other's logs.
+package artifacts
+
+import (
+	"byzzfuzz/byzzfuzz/spec"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Run is the artifact directory of a single test run
+type Run struct {
+	ID    string
+	Dir   string
+	Start time.Time
+}
+
+// Summary of the outcome of a run, written to summary.json
+type Summary struct {
+	RunID     string    `json:"run_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Passed    bool      `json:"passed"`
+	Agreement bool      `json:"agreement"`
+	Validity  bool      `json:"validity"`
+	Integrity bool      `json:"integrity"`
+	Liveness  bool      `json:"liveness"`
+	Spec      bool      `json:"spec"`
+	// Set if the run was interrupted, its outcome is not meaningful then
+	Terminated     bool             `json:"terminated,omitempty"`
+	SpecViolations []spec.Violation `json:"spec_violations,omitempty"`
+}
+
+// NewRun creates a new run directory under baseDir, named after the run ID.
+// Run IDs are derived from the start time, so that directories sort chronologically.
+func NewRun(baseDir string) (*Run, error) {
+	err := os.MkdirAll(baseDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create runs directory: %s", err)
+	}
+	for {
+		start := time.Now()
+		id := start.Format("20060102-150405.000000")
+		dir := filepath.Join(baseDir, id)
+		err := os.Mkdir(dir, 0755)
+		if os.IsExist(err) {
+			// Another run started in the same microsecond
+			time.Sleep(time.Microsecond)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to create run directory: %s", err)
+		}
+		return &Run{ID: id, Dir: dir, Start: start}, nil
+	}
+}
+
+// OpenRun returns the run stored in dir, which an earlier NewRun created
+func OpenRun(dir string) *Run {
+	run := &Run{ID: filepath.Base(dir), Dir: dir}
+	start, err := time.ParseInLocation("20060102-150405.000000", run.ID, time.Local)
+	if err == nil {
+		run.Start = start
+	}
+	return run
+}
+
+func (r *Run) ConfigPath() string {
+	return filepath.Join(r.Dir, "config.json")
+}
+
+// NodesLogPath is the stdout and stderr of the nodes, if the backend runs them as a separate process
+func (r *Run) NodesLogPath() string {
+	return filepath.Join(r.Dir, "nodes.stdout.log")
+}
+
+// CheckerLogPath is the log of the testing server, with all events and the decisions of the test case
+func (r *Run) CheckerLogPath() string {
+	return filepath.Join(r.Dir, "checker.log")
+}
+
+func (r *Run) SpecLogPath() string {
+	return filepath.Join(r.Dir, "spec.log")
+}
+
+// CoveragePath lists the protocol states and message type transitions of the run, one per line
+func (r *Run) CoveragePath() string {
+	return filepath.Join(r.Dir, "coverage.log")
+}
+
+// SchedulePath is the order of the message events of the run and the decisions of the filters, which replay follows
+func (r *Run) SchedulePath() string {
+	return filepath.Join(r.Dir, "schedule.jsonl")
+}
+
+// TLATracePath is the run as a trace module of the Tendermint TLA+ spec, files of TLA+ modules are named after them
+func (r *Run) TLATracePath() string {
+	return filepath.Join(r.Dir, "ByzzFuzzTrace.tla")
+}
+
+func (r *Run) TLAConfigPath() string {
+	return filepath.Join(r.Dir, "ByzzFuzzTrace.cfg")
+}
+
+func (r *Run) SummaryPath() string {
+	return filepath.Join(r.Dir, "summary.json")
+}
+
+func (r *Run) WriteConfig(config string) error {
+	return os.WriteFile(r.ConfigPath(), []byte(config+"\n"), 0644)
+}
+
+func (r *Run) WriteSpecLog(events []spec.Event) error {
+	f, err := os.Create(r.SpecLogPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return spec.WriteLog(f, events)
+}
+
+func (r *Run) WriteCoverage(keys []string) error {
+	return os.WriteFile(r.CoveragePath(), []byte(strings.Join(keys, "\n")+"\n"), 0644)
+}
+
+// WriteSummary fills in the run ID and times, and writes the summary
+func (r *Run) WriteSummary(summary Summary) error {
+	summary.RunID = r.ID
+	summary.Start = r.Start
+	summary.End = time.Now()
+	js, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.SummaryPath(), append(js, '\n'), 0644)
+}
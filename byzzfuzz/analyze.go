@@ -0,0 +1,230 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/byzzfuzz/spec"
+	"byzzfuzz/liveness"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/netrixframework/netrix/config"
+	"github.com/netrixframework/netrix/context"
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+const (
+	replicaEventLog = "Replica event"
+	// Logged by Netrix for every event it receives, at debug level
+	receivedEventLog  = "Received event"
+	partitionLog      = "Partitioned replicas"
+	messageSendType   = "MessageSend"
+	messageRecvType   = "MessageReceive"
+	timeoutStartType  = "TimeoutStart"
+	timeoutEndType    = "TimeoutEnd"
+	replayedPartition = "Replayed partition"
+	replayedProposal  = "Replayed proposal"
+	replayedFinish    = "Replayed end of test"
+	replayedForget    = "Replayed crash or restart"
+)
+
+// ErrNoReplicaEvents is returned by AnalyzeLog for checker logs without replica events: runs recorded before
+// logReplicaEvents existed, at the default info level
+var ErrNoReplicaEvents = errors.New("no replica events in the log, runs recorded before replica events were logged at info level can only be replayed from a debug level log")
+
+// logReplicaEvents logs the events that replicas report besides messages, such as new steps and commits,
+// so that AnalyzeLog can replay them
+func logReplicaEvents(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+	eType, ok := e.Type.(*types.GenericEventType)
+	if !ok {
+		return
+	}
+	c.Logger().With(log.LogParams{
+		"replica": e.Replica,
+		"type":    eType.T,
+		"params":  eType.Params,
+	}).Info(replicaEventLog)
+	return
+}
+
+// logLine holds the fields of all checker log lines that AnalyzeLog replays
+type logLine struct {
+	Msg  string    `json:"msg"`
+	Time time.Time `json:"time"`
+	// Replica events, and the type of consensus messages
+	Replica string            `json:"replica"`
+	Type    string            `json:"type"`
+	Params  map[string]string `json:"params"`
+	// Consensus messages
+	IsReceive bool   `json:"is_receive"`
+	From      string `json:"sent_from"`
+	To        string `json:"sent_to"`
+	Height    int    `json:"height"`
+	Round     int    `json:"round"`
+	// Proposed block IDs
+	BlockID  json.RawMessage `json:"block_id"`
+	Proposer *string         `json:"proposer"`
+	Genuine  bool            `json:"genuine"`
+	// Partitions, and the label of crashed and restarted nodes
+	Partition string `json:"partition"`
+	Node      string `json:"node"`
+}
+
+// AnalyzeLog replays the checker log of a run of the instance through the oracles of ByzzFuzzInst, without
+// running any nodes. The returned test case ends in the state the run would end in with the current oracles.
+//
+// Messages are not logged, so they are replayed as generic events with their logged type, height and round.
+// Events happen at the time they were logged, which is precise to the second.
+// Logs of runs before replica events were logged at info level can only be replayed if they were logged at
+// debug level.
+func AnalyzeLog(r io.Reader, c ByzzFuzzInstanceConfig, logger *log.Logger) (*testlib.TestCase, error) {
+	lines := make([]logLine, 0)
+	logsReplicaEvents := false
+	decoder := json.NewDecoder(r)
+	for {
+		var line logLine
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		logsReplicaEvents = logsReplicaEvents || line.Msg == replicaEventLog
+		lines = append(lines, line)
+	}
+
+	events := make([]*types.Event, 0, len(lines))
+	addEvent := func(line logLine, replica string, t string, params map[string]string) {
+		eType := types.NewGenericEventType(params, t)
+		events = append(events, types.NewEvent(types.ReplicaID(replica), eType, eType.String(),
+			types.EventID(len(events)), line.Time.UnixNano()))
+	}
+	var partition *util.Partition
+	hasReplicaEvent := false
+	for _, line := range lines {
+		switch line.Msg {
+		case partitionLog:
+			partition = parsePartition(line.Partition)
+			addEvent(line, "", replayedPartition, map[string]string{"partition": line.Partition})
+		case replicaEventLog, receivedEventLog:
+			// Runs that log replica events may log them at debug level as well
+			if (line.Msg == receivedEventLog) == logsReplicaEvents {
+				continue
+			}
+			switch line.Type {
+			case messageSendType, messageRecvType, timeoutStartType, timeoutEndType:
+				continue
+			}
+			hasReplicaEvent = true
+			addEvent(line, line.Replica, line.Type, line.Params)
+		case consensusMessageLog:
+			if !line.IsReceive {
+				continue
+			}
+			addEvent(line, "", spec.ReplayedMessageType, map[string]string{
+				"from":   line.From,
+				"to":     line.To,
+				"type":   line.Type,
+				"height": strconv.Itoa(line.Height),
+				"round":  strconv.Itoa(line.Round),
+			})
+		case blockIdLog:
+			var blockID struct {
+				Hash string `json:"hash"`
+			}
+			err := json.Unmarshal(line.BlockID, &blockID)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block ID: %s", err)
+			}
+			// Logs of earlier runs do not name the signer, count their proposals as genuine then
+			proposer, genuine := "", true
+			if line.Proposer != nil {
+				proposer, genuine = *line.Proposer, line.Genuine
+			}
+			addEvent(line, "", replayedProposal, map[string]string{
+				"hash":     blockID.Hash,
+				"proposer": proposer,
+				"genuine":  strconv.FormatBool(genuine),
+			})
+		case liveness.TestFinishedMessage:
+			addEvent(line, "", replayedFinish, map[string]string{})
+		case crashNodeLog, restartNodeLog:
+			addEvent(line, "", replayedForget, map[string]string{"node": line.Node})
+		}
+	}
+	if partition == nil {
+		return nil, errors.New("no partition in the log")
+	}
+	if !hasReplicaEvent {
+		return nil, ErrNoReplicaEvents
+	}
+
+	roundSkips := spec.NewRoundSkipMonitor(c.sysParams.F, roundSkipGracePeriod)
+	filters := testlib.NewFilterSet()
+	filters.AddFilter(replayLogged(roundSkips))
+	testcase := testlib.NewTestCase("AnalyzeLog", 0, oracles(c.Faulty, roundSkips.ReplayCondition()), filters)
+	testcase.Logger = logger.With(log.LogParams{"testcase": testcase.Name})
+
+	ids := make([]types.ReplicaID, 0)
+	for _, part := range partition.Parts {
+		ids = append(ids, part.ReplicaSet.Iter()...)
+	}
+	root := context.NewRootContext(&config.Config{NumReplicas: len(ids)}, logger)
+	for _, id := range ids {
+		root.Replicas.Add(&types.Replica{ID: id})
+	}
+	driver := testlib.NewTestDriver(root, testcase)
+	for _, e := range events {
+		driver.Step(e)
+	}
+	return testcase, nil
+}
+
+// replayLogged restores what the filters of ByzzFuzzInst kept in the context of a run from the replayed events
+func replayLogged(roundSkips *spec.RoundSkipMonitor) testlib.FilterFunc {
+	return func(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+		eType, ok := e.Type.(*types.GenericEventType)
+		if !ok {
+			return
+		}
+		switch eType.T {
+		case replayedPartition:
+			c.Vars.Set("partition", parsePartition(eType.Params["partition"]))
+		case replayedProposal:
+			spec.RecordProposal(c, eType.Params["hash"], spec.Proposal{
+				Proposer: eType.Params["proposer"],
+				Genuine:  eType.Params["genuine"] == "true",
+			})
+		case replayedFinish:
+			liveness.MarkTestFinished(c)
+		case replayedForget:
+			roundSkips.Forget(eType.Params["node"])
+		}
+		return
+	}
+}
+
+// parsePartition reads a partition as logged by labelNodes, see util.Partition.String
+func parsePartition(s string) *util.Partition {
+	parts := make([]*util.Part, 0)
+	var part *util.Part
+	for _, line := range strings.Split(s, "\n") {
+		if label := strings.TrimPrefix(line, "Label: "); label != line {
+			part = &util.Part{ReplicaSet: util.NewReplicaSet(), Label: label}
+			parts = append(parts, part)
+		} else if members := strings.TrimPrefix(line, "Members: "); members != line && part != nil {
+			for _, id := range strings.Split(members, ",") {
+				if id != "" {
+					part.ReplicaSet.Add(&types.Replica{ID: types.ReplicaID(id)})
+				}
+			}
+		}
+	}
+	return util.NewPartition(parts...)
+}
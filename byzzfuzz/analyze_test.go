@@ -0,0 +1,245 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/liveness"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/netrixframework/netrix/config"
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/common"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// checkerLog writes synthetic checker log lines like the filters of ByzzFuzzInst log them during a run
+type checkerLog struct {
+	lines []string
+	start time.Time
+}
+
+func newCheckerLog() *checkerLog {
+	l := &checkerLog{start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	parts := make([]*util.Part, 4)
+	for i := range parts {
+		parts[i] = &util.Part{ReplicaSet: util.NewReplicaSet(), Label: nodeLabel(i)}
+		parts[i].ReplicaSet.Add(&types.Replica{ID: types.ReplicaID(fmt.Sprintf("replica%d", i))})
+	}
+	l.log(partitionLog, map[string]interface{}{"partition": util.NewPartition(parts...).String()})
+	return l
+}
+
+func (l *checkerLog) log(msg string, fields map[string]interface{}) {
+	fields["msg"] = msg
+	fields["level"] = "info"
+	fields["time"] = l.start.Add(time.Duration(len(l.lines)) * time.Second)
+	line, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+	l.lines = append(l.lines, string(line))
+}
+
+// proposal logs a proposal of the block signed by the node, as logged by logBlockId
+func (l *checkerLog) proposal(hash string, node int) {
+	l.logProposal(hash, node, true)
+}
+
+// corruptedProposal logs a proposal of the block that a faulty node sent through a corrupted message
+func (l *checkerLog) corruptedProposal(hash string, node int) {
+	l.logProposal(hash, node, false)
+}
+
+func (l *checkerLog) logProposal(hash string, node int, genuine bool) {
+	l.log(blockIdLog, map[string]interface{}{
+		"block_id": map[string]string{"hash": hash},
+		"proposer": nodeLabel(node),
+		"genuine":  genuine,
+	})
+}
+
+func (l *checkerLog) commit(node int, height int, hash string) {
+	l.log(replicaEventLog, map[string]interface{}{
+		"replica": fmt.Sprintf("replica%d", node),
+		"type":    "Committing block",
+		"params":  map[string]string{"height": fmt.Sprint(height), "block_id": hash},
+	})
+}
+
+func (l *checkerLog) commitAll(height int, hash string) {
+	for node := 0; node < 4; node++ {
+		l.commit(node, height, hash)
+	}
+}
+
+func (l *checkerLog) finish() {
+	l.log(liveness.TestFinishedMessage, map[string]interface{}{})
+}
+
+func (l *checkerLog) analyze(t *testing.T, faulty []int) (*testlib.TestCase, error) {
+	t.Helper()
+	logger := log.NewLogger(config.LogConfig{Format: "json", Path: filepath.Join(t.TempDir(), "analyze.log")})
+	inst := ByzzFuzzInstanceConfig{sysParams: common.NewSystemParams(4), Faulty: faulty}
+	return AnalyzeLog(strings.NewReader(strings.Join(l.lines, "\n")), inst, logger)
+}
+
+func TestAnalyzeLog(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		faulty []int
+		run    func(l *checkerLog)
+		want   string
+		// The run passed the liveness check
+		success bool
+	}{
+		{
+			name: "commit after the network healed",
+			run: func(l *checkerLog) {
+				l.proposal("AA", 1)
+				l.commitAll(1, "AA")
+				l.finish()
+				l.proposal("BB", 2)
+				l.commit(2, 2, "BB")
+			},
+			want:    testlib.SuccessStateLabel,
+			success: true,
+		},
+		{
+			name: "no commit after the network healed",
+			run: func(l *checkerLog) {
+				l.proposal("AA", 1)
+				l.commitAll(1, "AA")
+				l.finish()
+			},
+			want: testlib.StartStateLabel,
+		},
+		{
+			name: "different blocks at the same height",
+			run: func(l *checkerLog) {
+				l.proposal("AA", 1)
+				l.proposal("BB", 2)
+				l.commit(0, 1, "AA")
+				l.commit(1, 1, "BB")
+			},
+			want: DiffCommitsLabel,
+		},
+		{
+			name:   "block only a faulty node proposed through a corrupted message",
+			faulty: []int{0},
+			run: func(l *checkerLog) {
+				l.corruptedProposal("AA", 0)
+				l.commit(1, 1, "AA")
+			},
+			want: InvalidCommitLabel,
+		},
+		{
+			name:   "block a faulty node proposed in its own turn",
+			faulty: []int{0},
+			run: func(l *checkerLog) {
+				l.proposal("AA", 0)
+				l.commitAll(1, "AA")
+				l.finish()
+				l.proposal("BB", 2)
+				l.commit(2, 2, "BB")
+			},
+			want:    testlib.SuccessStateLabel,
+			success: true,
+		},
+		{
+			name:   "block a correct node proposed as well",
+			faulty: []int{0},
+			run: func(l *checkerLog) {
+				l.corruptedProposal("AA", 0)
+				l.proposal("AA", 1)
+				l.commitAll(1, "AA")
+				l.finish()
+				l.proposal("BB", 2)
+				l.commit(2, 2, "BB")
+			},
+			want:    testlib.SuccessStateLabel,
+			success: true,
+		},
+		{
+			name: "block nobody proposed",
+			run: func(l *checkerLog) {
+				l.commit(0, 1, "AA")
+			},
+			want: InvalidCommitLabel,
+		},
+		{
+			name: "second block of a replica at the same height",
+			run: func(l *checkerLog) {
+				l.proposal("AA", 1)
+				l.proposal("BB", 2)
+				l.commit(0, 1, "AA")
+				l.commit(0, 1, "BB")
+			},
+			want: DoubleCommitLabel,
+		},
+		{
+			// Restarted nodes commit their last block again when they replay it
+			name: "same block committed again after a restart",
+			run: func(l *checkerLog) {
+				l.proposal("AA", 1)
+				l.commitAll(1, "AA")
+				l.commit(0, 1, "AA")
+				l.finish()
+				l.proposal("BB", 2)
+				l.commit(2, 2, "BB")
+			},
+			want:    testlib.SuccessStateLabel,
+			success: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newCheckerLog()
+			test.run(l)
+			testcase, err := l.analyze(t, test.faulty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if label := testcase.StateMachine.CurState().Label; label != test.want {
+				t.Errorf("replay ended in %s, want %s", label, test.want)
+			}
+			if success := testcase.StateMachine.InSuccessState(); success != test.success {
+				t.Errorf("liveness %v, want %v", success, test.success)
+			}
+		})
+	}
+}
+
+func TestAnalyzeLogWithoutReplicaEvents(t *testing.T) {
+	l := newCheckerLog()
+	l.proposal("AA", 1)
+	l.finish()
+	if _, err := l.analyze(t, nil); !errors.Is(err, ErrNoReplicaEvents) {
+		t.Errorf("got error %v, want %v", err, ErrNoReplicaEvents)
+	}
+}
+
+func TestAnalyzeLogFromDebugLevel(t *testing.T) {
+	l := newCheckerLog()
+	l.proposal("AA", 1)
+	l.proposal("BB", 2)
+	// Runs before replica events were logged at info level, with --log-level debug
+	for node, hash := range []string{"AA", "BB"} {
+		l.log(receivedEventLog, map[string]interface{}{
+			"replica": fmt.Sprintf("replica%d", node),
+			"type":    "Committing block",
+			"params":  map[string]string{"height": "1", "block_id": hash},
+		})
+	}
+	testcase, err := l.analyze(t, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if label := testcase.StateMachine.CurState().Label; label != DiffCommitsLabel {
+		t.Errorf("replay ended in %s, want %s", label, DiffCommitsLabel)
+	}
+}
@@ -12,8 +12,10 @@ import (
 	"github.com/netrixframework/tendermint-testing/util"
 )
 
+// BaselineTestCase randomly drops and corrupts messages. All random choices are derived from seed.
 func BaselineTestCase(
 	sp *common.SystemParams,
+	seed int64,
 	dropPercent int,
 	corruptPercent int) *testlib.TestCase {
 
@@ -28,15 +30,15 @@ func BaselineTestCase(
 
 	filters.AddFilter(logConsensusMessages)
 
-	rand.Seed(time.Now().UnixNano())
+	r := rand.New(rand.NewSource(seed))
 
 	filters.AddFilter(testlib.If(
 		testlib.IsMessageSend().And(
-			randomlyPick(dropPercent)).And(IsConsensusMessage())).Then(dropMessageLoudly))
+			randomlyPick(r, dropPercent)).And(IsConsensusMessage())).Then(dropMessageLoudly))
 	filters.AddFilter(testlib.If(
 		testlib.IsMessageSend().And(
 			common.IsMessageFromPart("node0").And(
-				randomlyPick(corruptPercent))).And(IsConsensusMessage())).Then(garbleMessage()))
+				randomlyPick(r, corruptPercent))).And(IsConsensusMessage())).Then(garbleMessage(r)))
 
 	testcase := testlib.NewTestCase("Baseline", 2*time.Minute, sm, filters)
 	testcase.SetupFunc(common.Setup(sp, labelNodes, liveness.SetupLivenessTimer(time.Minute)))
@@ -52,12 +54,12 @@ func IsConsensusMessage() testlib.Condition {
 	}
 }
 
-func randomlyPick(pct int) testlib.Condition {
+func randomlyPick(r *rand.Rand, pct int) testlib.Condition {
 	return func(e *types.Event, c *testlib.Context) bool {
 		if liveness.IsTestFinished(e, c) {
 			return false
 		}
-		n := rand.Intn(100)
+		n := r.Intn(100)
 		if n < pct {
 			return true
 		}
@@ -65,7 +67,7 @@ func randomlyPick(pct int) testlib.Condition {
 	}
 }
 
-func garbleMessage() testlib.Action {
+func garbleMessage(r *rand.Rand) testlib.Action {
 	return func(e *types.Event, c *testlib.Context) []*types.Message {
 		c.Logger().Info("Corrupt (bitwise)")
 		m, ok := c.GetMessage(e)
@@ -78,11 +80,11 @@ func garbleMessage() testlib.Action {
 		}
 
 		// Select a byte to corrupt
-		byteIndex := rand.Intn(len(tMsg.MsgB))
+		byteIndex := r.Intn(len(tMsg.MsgB))
 		origByte := tMsg.MsgB[byteIndex]
 
 		// Select a bit to corrupt
-		bitIndex := rand.Intn(8)
+		bitIndex := r.Intn(8)
 		// Flip
 		corByte := origByte ^ (1 << bitIndex)
 		tMsg.MsgB[byteIndex] = corByte
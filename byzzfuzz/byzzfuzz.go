@@ -3,6 +3,7 @@ package byzzfuzz
 import (
 	"byzzfuzz/byzzfuzz/spec"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"math/rand"
@@ -15,7 +16,10 @@ import (
 
 func ByzzFuzzExpectNewRound(sp *common.SystemParams) (*testlib.TestCase, chan spec.Event) {
 	isolatedValidator := 0
-	otherNodes := []int{1, 2, 3}
+	otherNodes := make([]int, 0, sp.N-1)
+	for i := 1; i < sp.N; i++ {
+		otherNodes = append(otherNodes, i)
+	}
 	faulty := 1
 	drops := []MessageDrop{
 		// Isolate isolatedValidator in round 0
@@ -23,7 +27,7 @@ func ByzzFuzzExpectNewRound(sp *common.SystemParams) (*testlib.TestCase, chan sp
 		{Step: 2, Partition: Partition{{isolatedValidator}, otherNodes}},
 	}
 	// Change all votes from faulty to nil
-	allNodes := []int{0, 1, 2, 3}
+	allNodes := append([]int{isolatedValidator}, otherNodes...)
 	corruptions := []MessageCorruption{
 		// Round 0
 		{Step: 1, From: faulty, To: allNodes, Corruption: ChangeVoteToNil},
@@ -42,32 +46,153 @@ func ByzzFuzzExpectNewRound(sp *common.SystemParams) (*testlib.TestCase, chan sp
 		{Step: 14, From: faulty, To: allNodes, Corruption: ChangeVoteToNil},
 	}
 
-	return ByzzFuzzInst(sp, drops, corruptions, time.Minute, time.Minute)
+	return ByzzFuzzInst(InstanceOptions{
+		SysParams:       sp,
+		Drops:           drops,
+		Corruptions:     corruptions,
+		Faulty:          []int{faulty},
+		Timeout:         time.Minute,
+		LivenessTimeout: time.Minute,
+	})
 }
 
-func InstanceFromJson(r io.Reader) (ByzzFuzzInstanceConfig, error) {
+func InstanceFromJson(r io.Reader, sp *common.SystemParams) (ByzzFuzzInstanceConfig, error) {
 	instconf := ByzzFuzzInstanceConfig{}
 	decoder := json.NewDecoder(r)
 	err := decoder.Decode(&instconf)
 	if err != nil {
 		return instconf, err
 	}
-	instconf.sysParams = sysParams
+	instconf.sysParams = sp
 	instconf.Timeout = 1 * time.Minute
 	instconf.LivenessTimeout = 1 * time.Minute
-	return instconf, nil
+	if len(instconf.Faulty) == 0 {
+		// Older configs do not list the faulty nodes, they are implied by the corruptions
+		instconf.Faulty = instconf.corruptingNodes()
+	}
+	return instconf, instconf.Validate()
 }
 
 type ByzzFuzzInstanceConfig struct {
 	sysParams       *common.SystemParams
 	Drops           []MessageDrop       `json:"drops"`
 	Corruptions     []MessageCorruption `json:"corruptions"`
+	Delays          []MessageDelay      `json:"delays,omitempty"`
+	Reorders        []MessageReorder    `json:"reorders,omitempty"`
+	Crashes         []NodeCrash         `json:"crashes,omitempty"`
+	Timeouts        []ConsensusTimeouts `json:"timeouts,omitempty"`
+	Faulty          []int               `json:"faulty_nodes,omitempty"`
+	Seed            int64               `json:"seed,omitempty"`
 	Timeout         time.Duration       `json:"timeout"`
 	LivenessTimeout time.Duration       `json:"liveness_timeout"`
 }
 
-func (c *ByzzFuzzInstanceConfig) TestCase() (*testlib.TestCase, chan spec.Event) {
-	return ByzzFuzzInst(c.sysParams, c.Drops, c.Corruptions, c.Timeout, c.LivenessTimeout)
+// Validate checks that the config stays within the fault model: at most f faulty nodes,
+// and only faulty nodes corrupt messages.
+func (c *ByzzFuzzInstanceConfig) Validate() error {
+	sp := c.sysParams
+	if len(c.Faulty) > sp.F {
+		return fmt.Errorf("%d faulty nodes configured, but at most f=%d are tolerated", len(c.Faulty), sp.F)
+	}
+	for _, node := range c.Faulty {
+		if node < 0 || node >= sp.N {
+			return fmt.Errorf("faulty node %d does not exist in a cluster of %d nodes", node, sp.N)
+		}
+	}
+	corrupting := c.corruptingNodes()
+	if len(corrupting) > sp.F {
+		return fmt.Errorf("messages from %d distinct nodes are corrupted, but at most f=%d are tolerated", len(corrupting), sp.F)
+	}
+	for _, node := range corrupting {
+		if !partContains(c.Faulty, node) {
+			return fmt.Errorf("corruption of messages from node %d, which is not faulty", node)
+		}
+	}
+	for _, corruption := range c.Corruptions {
+		for _, to := range corruption.To {
+			if to < 0 || to >= sp.N {
+				return fmt.Errorf("corruption of messages to node %d, which does not exist in a cluster of %d nodes", to, sp.N)
+			}
+		}
+	}
+	for _, drop := range c.Drops {
+		if err := validatePartition(sp.N, drop.Partition); err != nil {
+			return fmt.Errorf("drop at step %d: %s", drop.Step, err)
+		}
+	}
+	err := validateGossip(c.Drops, c.Corruptions)
+	if err != nil {
+		return err
+	}
+	err = validateCrashes(sp.N, c.Crashes)
+	if err != nil {
+		return err
+	}
+	err = validateTimeouts(sp.N, c.Timeouts)
+	if err != nil {
+		return err
+	}
+	return validateDelays(sp, c.Delays, c.Reorders)
+}
+
+// corruptingNodes returns the distinct senders of corrupted messages, in ascending order
+func (c *ByzzFuzzInstanceConfig) corruptingNodes() []int {
+	nodes := make([]int, 0)
+	for _, corruption := range c.Corruptions {
+		if !partContains(nodes, corruption.From) {
+			nodes = append(nodes, corruption.From)
+		}
+	}
+	sort.Ints(nodes)
+	return nodes
+}
+
+// Options returns the faults and timeouts of the instance, for ByzzFuzzInst. Set Nodes for instances with crashes.
+func (c *ByzzFuzzInstanceConfig) Options() InstanceOptions {
+	return InstanceOptions{
+		SysParams:       c.sysParams,
+		Drops:           c.Drops,
+		Corruptions:     c.Corruptions,
+		Delays:          c.Delays,
+		Reorders:        c.Reorders,
+		Crashes:         c.Crashes,
+		Faulty:          c.Faulty,
+		Timeout:         c.Timeout,
+		LivenessTimeout: c.LivenessTimeout,
+	}
+}
+
+// clone returns a deep copy of the config, so that the copy can be modified independently
+func (c *ByzzFuzzInstanceConfig) clone() ByzzFuzzInstanceConfig {
+	clone := *c
+	clone.Drops = make([]MessageDrop, len(c.Drops))
+	for i, drop := range c.Drops {
+		clone.Drops[i] = drop
+		clone.Drops[i].Partition = make(Partition, len(drop.Partition))
+		for j, block := range drop.Partition {
+			clone.Drops[i].Partition[j] = append([]int{}, block...)
+		}
+	}
+	clone.Corruptions = make([]MessageCorruption, len(c.Corruptions))
+	for i, corruption := range c.Corruptions {
+		clone.Corruptions[i] = corruption
+		clone.Corruptions[i].To = append([]int{}, corruption.To...)
+	}
+	if c.Delays != nil {
+		clone.Delays = make([]MessageDelay, len(c.Delays))
+		for i, delay := range c.Delays {
+			clone.Delays[i] = delay
+			clone.Delays[i].Partition = make(Partition, len(delay.Partition))
+			for j, block := range delay.Partition {
+				clone.Delays[i].Partition[j] = append([]int{}, block...)
+			}
+		}
+	}
+	clone.Reorders = append([]MessageReorder(nil), c.Reorders...)
+	clone.Crashes = append([]NodeCrash(nil), c.Crashes...)
+	clone.Timeouts = append([]ConsensusTimeouts(nil), c.Timeouts...)
+	clone.Faulty = append([]int{}, c.Faulty...)
+	return clone
 }
 
 func (c *ByzzFuzzInstanceConfig) Json() string {
@@ -78,36 +203,100 @@ func (c *ByzzFuzzInstanceConfig) Json() string {
 	return string(json)
 }
 
+// Upper bound on corruption seeds. The seed is, among other things, used to select a previously seen message ID.
+// It should be large enough that any message in the history is reachable, so we cautiously pick an upper bound
+// to the number of messages we expect the cluster to exchange in our short test period.
+const maxCorruptionSeed = 10_000
+
+// ByzzFuzzRandom generates a random instance. The campaign source r only determines the seed of the instance,
+// all random choices of the instance are derived from that seed, see ByzzFuzzFromSeed.
+// With sampleTimeouts, the consensus timeouts of every node are random as well.
 func ByzzFuzzRandom(sp *common.SystemParams,
 	r *rand.Rand,
+	scope Scope,
 	nDrops int,
 	nCorruptions int,
+	nDelays int,
+	nReorders int,
 	steps int,
-	timeout time.Duration) ByzzFuzzInstanceConfig {
+	timeout time.Duration,
+	sampleTimeouts bool) ByzzFuzzInstanceConfig {
+	return ByzzFuzzFromSeed(sp, r.Int63(), scope, nDrops, nCorruptions, nDelays, nReorders, steps, timeout, sampleTimeouts)
+}
 
+// ByzzFuzzFromSeed deterministically generates the instance with the given seed.
+func ByzzFuzzFromSeed(sp *common.SystemParams,
+	seed int64,
+	scope Scope,
+	nDrops int,
+	nCorruptions int,
+	nDelays int,
+	nReorders int,
+	steps int,
+	timeout time.Duration,
+	sampleTimeouts bool) ByzzFuzzInstanceConfig {
+
+	r := rand.New(rand.NewSource(seed))
 	drops := make([]MessageDrop, nDrops)
 	// Use a random permutation to avoid two drops for the same step
 	dropSteps := r.Perm(steps)
 	for i := range drops {
 		drops[i] = MessageDrop{
 			Step:      dropSteps[i],
-			Partition: RandomPartition(r),
+			Partition: RandomPartition(sp, r),
+		}
+		if scope == GossipScope {
+			drops[i].GossipType = randomGossipType(r)
 		}
 	}
 
-	byzantineNode := r.Intn(sp.N)
+	// Up to f colluding faulty nodes, fixed throughout the execution
+	faulty := r.Perm(sp.N)[0:(1 + r.Intn(sp.F))]
+	sort.Ints(faulty)
 	corruptions := make([]MessageCorruption, nCorruptions)
 	for i := range corruptions {
 		step := r.Intn(steps)
 		corruptions[i] = MessageCorruption{
 			Step:       step,
-			From:       byzantineNode,
+			From:       faulty[r.Intn(len(faulty))],
 			To:         randomNonEmptySubset(r, sp.N),
-			Corruption: randomCorruption(r, step),
+			Corruption: randomCorruption(r, scope, step),
+			Seed:       r.Intn(maxCorruptionSeed + 1),
+		}
+		if scope == GossipScope {
+			corruptions[i].GossipType = randomGossipType(r)
+			if corruptions[i].GossipType != "" {
+				corruptions[i].Corruption = randomGossipCorruption(r, corruptions[i].GossipType)
+			}
 		}
 	}
 
-	return ByzzFuzzInstanceConfig{sp, drops, corruptions, timeout, time.Minute}
+	// Generated last, so that instances without delays and reorders are the same as before they were introduced
+	var delays []MessageDelay
+	for i := 0; i < nDelays; i++ {
+		delays = append(delays, randomDelay(sp, r, steps))
+	}
+	var reorders []MessageReorder
+	for i := 0; i < nReorders; i++ {
+		reorders = append(reorders, randomReorder(sp, r, steps))
+	}
+	var timeouts []ConsensusTimeouts
+	if sampleTimeouts {
+		timeouts = randomTimeouts(sp.N, r)
+	}
+
+	return ByzzFuzzInstanceConfig{
+		sysParams:       sp,
+		Drops:           drops,
+		Corruptions:     corruptions,
+		Delays:          delays,
+		Reorders:        reorders,
+		Timeouts:        timeouts,
+		Faulty:          faulty,
+		Seed:            seed,
+		Timeout:         timeout,
+		LivenessTimeout: time.Minute,
+	}
 }
 
 func randomNonEmptySubset(r *rand.Rand, n int) []int {
@@ -116,14 +305,21 @@ func randomNonEmptySubset(r *rand.Rand, n int) []int {
 	return subset
 }
 
-func randomCorruption(r *rand.Rand, step int) CorruptionType {
+func randomCorruption(r *rand.Rand, scope Scope, step int) CorruptionType {
+	proposalTypes, voteTypes := ProposalCorruptionTypes, VoteCorruptionTypes
+	switch scope {
+	case AnyScope, GossipScope:
+		proposalTypes, voteTypes = ProposalCorruptionTypesAnyScope, VoteCorruptionTypesAnyScope
+	case EquivocationScope:
+		proposalTypes, voteTypes = ProposalCorruptionTypesEquivocation, VoteCorruptionTypesEquivocation
+	}
 	switch step % 3 {
 	case 0:
-		return ProposalCorruptionTypes[r.Intn(len(ProposalCorruptionTypes))]
+		return proposalTypes[r.Intn(len(proposalTypes))]
 	case 1:
 		fallthrough
 	case 2:
-		return VoteCorruptionTypes[r.Intn(len(VoteCorruptionTypes))]
+		return voteTypes[r.Intn(len(voteTypes))]
 	default:
 		panic("impossible")
 	}
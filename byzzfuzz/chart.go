@@ -0,0 +1,323 @@
+package byzzfuzz
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ChartOptions configure RenderChart
+type ChartOptions struct {
+	// Write a standalone HTML page with a legend, instead of a bare SVG image
+	HTML bool
+	// Draw every copy of a message that nodes send more than once. By default only the first copy is drawn,
+	// and it counts as delivered if any copy was.
+	AllCopies bool
+}
+
+// chartLine holds the fields of the checker log lines that RenderChart draws
+type chartLine struct {
+	Msg string `json:"msg"`
+	// Replica events
+	Replica string            `json:"replica"`
+	Type    string            `json:"type"`
+	Params  map[string]string `json:"params"`
+	// Consensus messages
+	IsReceive bool   `json:"is_receive"`
+	IsSend    bool   `json:"is_send"`
+	SentFrom  string `json:"sent_from"`
+	SentTo    string `json:"sent_to"`
+	Height    int    `json:"height"`
+	Round     int    `json:"round"`
+	// Corruptions
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Partitions
+	Partition string `json:"partition"`
+}
+
+type chartMessageKey struct {
+	from   string
+	to     string
+	mType  string
+	height int
+	round  int
+}
+
+type chartMessage struct {
+	chartMessageKey
+	sendRow int
+	// -1 if the message was not received
+	receiveRow int
+	corruption string
+}
+
+// chartMark is a new step or a commit of a node
+type chartMark struct {
+	node   string
+	row    int
+	label  string
+	commit bool
+}
+
+// chartBoundary is the first event of a height and round
+type chartBoundary struct {
+	row    int
+	height int
+	round  int
+}
+
+type chart struct {
+	nodes      []string
+	rows       int
+	messages   []*chartMessage
+	marks      []chartMark
+	boundaries []chartBoundary
+}
+
+// RenderChart draws the checker log of a run as a message sequence chart, with a lane per node and time going down.
+// Dropped messages end in a cross, corrupted messages are red. Log lines are drawn in the order they were logged,
+// since their timestamps are only precise to the second.
+func RenderChart(r io.Reader, w io.Writer, options ChartOptions) error {
+	c, err := readChart(r, options.AllCopies)
+	if err != nil {
+		return err
+	}
+	if options.HTML {
+		return c.writeHTML(w)
+	}
+	return c.writeSVG(w)
+}
+
+func readChart(r io.Reader, allCopies bool) (*chart, error) {
+	c := &chart{}
+	nodes := make(map[string]bool)
+	// Node labels by replica ID, replica events name the replica by ID
+	labels := make(map[string]string)
+	// Sent messages that were not received yet, first sent first
+	pending := make(map[chartMessageKey][]*chartMessage)
+	// First copy of every message, if only first copies are drawn
+	first := make(map[chartMessageKey]*chartMessage)
+	// The message sent by the last logged event, which a corruption logged after it applies to
+	var lastSent *chartMessage
+	var lastHeight, lastRound int
+	boundary := func(height int, round int) {
+		if len(c.boundaries) == 0 || height > lastHeight || (height == lastHeight && round > lastRound) {
+			c.boundaries = append(c.boundaries, chartBoundary{row: c.rows, height: height, round: round})
+			lastHeight, lastRound = height, round
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var line chartLine
+		// Event logs of campaigns start with the config, skip everything that is not a log line
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		switch line.Msg {
+		case partitionLog:
+			for _, part := range parsePartition(line.Partition).Parts {
+				if !strings.HasPrefix(part.Label, "node") {
+					continue
+				}
+				for _, id := range part.ReplicaSet.Iter() {
+					labels[string(id)] = part.Label
+				}
+			}
+		case consensusMessageLog:
+			key := chartMessageKey{from: line.SentFrom, to: line.SentTo, mType: line.Type, height: line.Height, round: line.Round}
+			nodes[key.from], nodes[key.to] = true, true
+			if line.IsSend {
+				if m, ok := first[key]; ok && !allCopies {
+					lastSent = m
+					continue
+				}
+				boundary(key.height, key.round)
+				m := &chartMessage{chartMessageKey: key, sendRow: c.rows, receiveRow: -1}
+				c.rows++
+				c.messages = append(c.messages, m)
+				first[key] = m
+				pending[key] = append(pending[key], m)
+				lastSent = m
+			} else if line.IsReceive {
+				lastSent = nil
+				// Corrupted messages can be received with another type or round than they were sent with
+				if len(pending[key]) == 0 {
+					continue
+				}
+				pending[key][0].receiveRow = c.rows
+				c.rows++
+				pending[key] = pending[key][1:]
+			}
+		case corruptionLog:
+			if m := lastSent; m != nil && m.from == line.From && m.to == line.To && m.height == line.Height && m.round == line.Round {
+				m.corruption = line.Type
+			}
+		case replicaEventLog:
+			lastSent = nil
+			node, ok := labels[line.Replica]
+			if !ok {
+				continue
+			}
+			switch line.Type {
+			case "newStep":
+				var height, round int
+				fmt.Sscan(line.Params["height"], &height)
+				fmt.Sscan(line.Params["round"], &round)
+				boundary(height, round)
+				c.marks = append(c.marks, chartMark{node: node, row: c.rows, label: strings.TrimPrefix(line.Params["step"], "RoundStep")})
+				c.rows++
+				nodes[node] = true
+			case "Committing block":
+				c.marks = append(c.marks, chartMark{node: node, row: c.rows, label: "Commit", commit: true})
+				c.rows++
+				nodes[node] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for node := range nodes {
+		c.nodes = append(c.nodes, node)
+	}
+	sortNodeLabels(c.nodes)
+	return c, nil
+}
+
+// sortNodeLabels sorts node labels by their index, node10 after node9
+func sortNodeLabels(labels []string) {
+	sort.Slice(labels, func(i, j int) bool {
+		if len(labels[i]) != len(labels[j]) {
+			return len(labels[i]) < len(labels[j])
+		}
+		return labels[i] < labels[j]
+	})
+}
+
+// Layout of the chart, in pixels
+const (
+	chartLaneWidth = 180
+	chartRowHeight = 14
+	chartMarginX   = 110
+	chartMarginY   = 50
+)
+
+func (c *chart) laneX(node string) int {
+	for i, n := range c.nodes {
+		if n == node {
+			return chartMarginX + i*chartLaneWidth + chartLaneWidth/2
+		}
+	}
+	return 0
+}
+
+func rowY(row int) int {
+	return chartMarginY + row*chartRowHeight + chartRowHeight/2
+}
+
+func (c *chart) writeSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	width := 2*chartMarginX + len(c.nodes)*chartLaneWidth
+	height := 2*chartMarginY + c.rows*chartRowHeight
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="10">`+"\n", width, height)
+	fmt.Fprint(bw, `<defs>
+<marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#222"/></marker>
+<marker id="arrow-corrupted" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#d22"/></marker>
+<marker id="cross" viewBox="0 0 10 10" refX="5" refY="5" markerWidth="8" markerHeight="8"><path d="M0,0 L10,10 M10,0 L0,10" stroke="#888" stroke-width="2"/></marker>
+<marker id="cross-corrupted" viewBox="0 0 10 10" refX="5" refY="5" markerWidth="8" markerHeight="8"><path d="M0,0 L10,10 M10,0 L0,10" stroke="#d22" stroke-width="2"/></marker>
+</defs>
+<style>
+.delivered { stroke: #222; }
+.dropped { stroke: #888; stroke-dasharray: 4 3; }
+.corrupted { stroke: #d22; }
+.Proposal { stroke-width: 2; }
+</style>
+`)
+
+	for _, b := range c.boundaries {
+		y := rowY(b.row) - chartRowHeight/2
+		fmt.Fprintf(bw, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="#bbb"/>`+"\n", y, width, y)
+		fmt.Fprintf(bw, `<text x="4" y="%d" font-weight="bold">H=%d R=%d</text>`+"\n", y+12, b.height, b.round)
+	}
+	for _, node := range c.nodes {
+		x := c.laneX(node)
+		fmt.Fprintf(bw, `<text x="%d" y="%d" text-anchor="middle" font-size="12" font-weight="bold">%s</text>`+"\n", x, chartMarginY-20, html.EscapeString(node))
+		fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#ccc" stroke-width="3"/>`+"\n", x, chartMarginY-10, x, height-chartMarginY)
+	}
+	for _, m := range c.marks {
+		x, y := c.laneX(m.node), rowY(m.row)
+		fill := "#36c"
+		if m.commit {
+			fill = "#2a2"
+		}
+		fmt.Fprintf(bw, `<circle cx="%d" cy="%d" r="4" fill="%s"/><text x="%d" y="%d" fill="%s">%s</text>`+"\n",
+			x, y, fill, x+6, y+3, fill, html.EscapeString(m.label))
+	}
+	for _, m := range c.messages {
+		x1, y1, x2 := c.laneX(m.from), rowY(m.sendRow), c.laneX(m.to)
+		class, title, marker := "delivered", "delivered", "arrow"
+		y2 := y1
+		switch {
+		case m.receiveRow >= 0:
+			y2 = rowY(m.receiveRow)
+		case m.corruption != "":
+			// Receives of corrupted messages are not logged, the filters pass them on when they are sent
+			title = "passed on"
+		default:
+			class, title, marker = "dropped", "dropped", "cross"
+			// Dropped messages end halfway, they never reach the lane of the receiver
+			x2 = (x1 + x2) / 2
+		}
+		if m.corruption != "" {
+			class += " corrupted"
+			title += ", corrupted: " + m.corruption
+			marker += "-corrupted"
+		}
+		fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%d" class="%s %s" marker-end="url(#%s)"><title>%s</title></line>`+"\n",
+			x1, y1, x2, y2, class, html.EscapeString(m.mType), marker,
+			html.EscapeString(fmt.Sprintf("%s %s to %s, H=%d R=%d, %s", m.mType, m.from, m.to, m.height, m.round, title)))
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-size="8" fill="#555">%s</text>`+"\n", x1+4, y1-2, html.EscapeString(m.mType))
+	}
+	fmt.Fprintln(bw, "</svg>")
+	return bw.Flush()
+}
+
+func (c *chart) writeHTML(w io.Writer) error {
+	_, err := fmt.Fprint(w, `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Message sequence chart</title>
+<style>
+body { font-family: sans-serif; }
+.legend span { margin-right: 2em; }
+</style>
+</head>
+<body>
+<p class="legend">
+<span>&#8212;&#9654; delivered</span>
+<span style="color: #888">- - &#10005; dropped</span>
+<span style="color: #d22">&#8212;&#9654; corrupted</span>
+<span style="color: #36c">&#9679; new step</span>
+<span style="color: #2a2">&#9679; commit</span>
+Hover over a message for details.
+</p>
+`)
+	if err != nil {
+		return err
+	}
+	err = c.writeSVG(w)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, "</body>\n</html>\n")
+	return err
+}
@@ -2,6 +2,7 @@ package byzzfuzz
 
 import (
 	"bytes"
+	"byzzfuzz/byzzfuzz/spec"
 
 	"github.com/netrixframework/netrix/log"
 	"github.com/netrixframework/netrix/testlib"
@@ -24,6 +25,20 @@ func (c *MessageCorruption) Action() testlib.Action {
 		return changeVoteRoundAnyScope(c.Seed)
 	case ChangeBlockIdAnyScope:
 		return changeBlockIdAnyScope(c.Seed)
+	case EquivocateVote:
+		return equivocateVote(c.To, c.Seed, false)
+	case EquivocateVoteNil:
+		return equivocateVote(c.To, c.Seed, true)
+	case EquivocateProposal:
+		return equivocateProposal(c.To, c.Seed)
+	case TruncateBlockPart:
+		return changeGossip(util.BlockPart, "TruncateBlockPart", truncateBlockPart)
+	case ChangeRoundStep:
+		return changeGossip(util.NewRoundStep, "ChangeRoundStep", changeRoundStep)
+	case LieHasVote:
+		return changeGossip(util.HasVote, "LieHasVote", lieHasVote(c.Seed))
+	case LieVoteSetMaj23:
+		return changeGossip(util.VoteSetMaj23, "LieVoteSetMaj23", lieVoteSetMaj23(c.Seed))
 	default:
 		panic("Invalid type of corruption")
 	}
@@ -74,7 +89,7 @@ func changeVoteToNil(e *types.Event, c *testlib.Context) []*types.Message {
 		"from":   getPartLabel(c, e.Replica),
 		"to":     getPartLabel(c, tMsg.To),
 		"type":   "ChangeVoteToNil",
-	}).Info("Corruption")
+	}).Info(corruptionLog)
 	return []*types.Message{c.NewMessage(message, msgB)}
 }
 
@@ -122,7 +137,7 @@ func changeVoteRound(e *types.Event, c *testlib.Context) []*types.Message {
 		"from":   getPartLabel(c, e.Replica),
 		"to":     getPartLabel(c, tMsg.To),
 		"type":   "ChangeVoteRound",
-	}).Info("Corruption")
+	}).Info(corruptionLog)
 	return []*types.Message{c.NewMessage(m, msgB)}
 }
 
@@ -149,7 +164,7 @@ func changeProposalToNil(e *types.Event, c *testlib.Context) []*types.Message {
 		"from":   getPartLabel(c, e.Replica),
 		"to":     getPartLabel(c, tMsg.To),
 		"type":   "ChangeProposalToNil",
-	}).Info("Corruption")
+	}).Info(corruptionLog)
 	return []*types.Message{c.NewMessage(message, newMsgB)}
 }
 
@@ -165,7 +180,7 @@ func omitMessage(e *types.Event, c *testlib.Context) []*types.Message {
 		"from":   getPartLabel(c, e.Replica),
 		"to":     getPartLabel(c, tMsg.To),
 		"type":   "Omit",
-	}).Info("Corruption")
+	}).Info(corruptionLog)
 	return []*types.Message{}
 }
 
@@ -215,7 +230,7 @@ func changeVoteRoundAnyScope(seed int) testlib.Action {
 			"from":   getPartLabel(c, e.Replica),
 			"to":     getPartLabel(c, tMsg.To),
 			"type":   "ChangeVoteRoundAnyScope",
-		}).Info("Corruption")
+		}).Info(corruptionLog)
 		return []*types.Message{c.NewMessage(m, msgB)}
 	}
 }
@@ -274,7 +289,7 @@ func changeBlockIdAnyScope(seed int) testlib.Action {
 			"to":       getPartLabel(c, tMsg.To),
 			"type":     "ChangeBlockIdAnyScope",
 			"block_id": newBlockId,
-		}).Info("Corruption")
+		}).Info(corruptionLog)
 		return []*types.Message{c.NewMessage(m, msgB)}
 	}
 }
@@ -299,9 +314,36 @@ func logBlockIds(e *types.Event, c *testlib.Context) (messages []*types.Message,
 	blockIds = append(blockIds, blockId)
 	c.Vars.Set("BF_blockids", blockIds)
 
-	c.Logger().With(log.LogParams{
+	params := log.LogParams{
 		"block_id": blockId,
-	}).Info("blockID")
+	}
+	// Proposals that a node sends itself come from its implementation, all others are forwarded or corrupted
+	if signer, ok := findProposalSigner(c, message); ok {
+		proposal := spec.Proposal{
+			Proposer: getPartLabel(c, signer.ID),
+			Genuine:  e.IsMessageSend() && signer.ID == message.From,
+		}
+		spec.RecordProposal(c, blockId.Hash.String(), proposal)
+		// For replays of the log, see AnalyzeLog
+		params["proposer"] = proposal.Proposer
+		params["genuine"] = proposal.Genuine
+	}
+	c.Logger().With(params).Info(blockIdLog)
 
 	return
 }
+
+const blockIdLog = "blockID"
+
+// findProposalSigner returns the replica whose key signed the proposal
+func findProposalSigner(c *testlib.Context, pMsg *util.TMessage) (*types.Replica, bool) {
+	for _, r := range c.Replicas.Iter() {
+		if isSignedBy(r, pMsg) {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// Logged by every corruption, after the message it corrupts was logged as sent
+const corruptionLog = "Corruption"
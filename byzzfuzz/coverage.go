@@ -0,0 +1,194 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// Coverage is the protocol state coverage of one or more runs: the states replicas went through,
+// and the transitions between the types of messages they received.
+type Coverage struct {
+	// Keyed by replica, height, round, step, locked round and valid round
+	States map[string]bool `json:"states"`
+	// Keyed by receiver, type of the previous received message and type of the message
+	Transitions map[string]bool `json:"transitions"`
+
+	mtx sync.Mutex
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{
+		States:      make(map[string]bool),
+		Transitions: make(map[string]bool),
+	}
+}
+
+func (cov *Coverage) Size() int {
+	cov.mtx.Lock()
+	defer cov.mtx.Unlock()
+	return len(cov.States) + len(cov.Transitions)
+}
+
+// Merge adds the coverage of other, and returns how many states and transitions were new
+func (cov *Coverage) Merge(other *Coverage) int {
+	cov.mtx.Lock()
+	defer cov.mtx.Unlock()
+	other.mtx.Lock()
+	defer other.mtx.Unlock()
+	added := 0
+	for state := range other.States {
+		if !cov.States[state] {
+			cov.States[state] = true
+			added++
+		}
+	}
+	for transition := range other.Transitions {
+		if !cov.Transitions[transition] {
+			cov.Transitions[transition] = true
+			added++
+		}
+	}
+	return added
+}
+
+// Keys returns the covered states and transitions in order, for logging
+func (cov *Coverage) Keys() []string {
+	cov.mtx.Lock()
+	defer cov.mtx.Unlock()
+	keys := make([]string, 0, len(cov.States)+len(cov.Transitions))
+	for state := range cov.States {
+		keys = append(keys, state)
+	}
+	for transition := range cov.Transitions {
+		keys = append(keys, transition)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (cov *Coverage) addState(key string) {
+	cov.mtx.Lock()
+	defer cov.mtx.Unlock()
+	cov.States[key] = true
+}
+
+func (cov *Coverage) addTransition(key string) {
+	cov.mtx.Lock()
+	defer cov.mtx.Unlock()
+	cov.Transitions[key] = true
+}
+
+// lockState is the locked and valid round of a replica, inferred from the messages it sends:
+// Tendermint locks on a block when it precommits it, and proposes with its valid round as POL round.
+type lockState struct {
+	height      int
+	lockedRound int
+	validRound  int
+}
+
+func lockStateKey(r types.ReplicaID) string {
+	return fmt.Sprintf("BF_coverage_lock_%s", r)
+}
+
+func lastReceivedTypeKey(r types.ReplicaID) string {
+	return fmt.Sprintf("BF_coverage_last_received_%s", r)
+}
+
+func getLockState(c *testlib.Context, r types.ReplicaID, height int) lockState {
+	if s, ok := c.Vars.Get(lockStateKey(r)); ok && s.(lockState).height == height {
+		return s.(lockState)
+	}
+	// Locks do not carry over to the next height
+	return lockState{height: height, lockedRound: -1, validRound: -1}
+}
+
+// recordCoverage adds the states and message type transitions of every event to cov
+func recordCoverage(cov *Coverage) testlib.FilterFunc {
+	return func(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+		if e.IsMessageSend() {
+			message, ok := util.GetMessageFromEvent(e, c)
+			if !ok || message.From != e.Replica {
+				return
+			}
+			height, round := message.HeightRound()
+			lock := getLockState(c, e.Replica, height)
+			switch message.Type {
+			case util.Precommit:
+				vote := message.Data.GetVote().Vote
+				if vote != nil && len(vote.BlockID.Hash) > 0 && round > lock.lockedRound {
+					lock.lockedRound = round
+					lock.validRound = round
+				}
+			case util.Proposal:
+				if polRound := int(message.Data.GetProposal().Proposal.PolRound); polRound > lock.validRound {
+					lock.validRound = polRound
+				}
+			default:
+				return
+			}
+			c.Vars.Set(lockStateKey(e.Replica), lock)
+			return
+		}
+
+		if e.IsMessageReceive() {
+			message, ok := util.GetMessageFromEvent(e, c)
+			if !ok {
+				return
+			}
+			prev, ok := c.Vars.GetString(lastReceivedTypeKey(message.To))
+			if !ok {
+				prev = "none"
+			}
+			cov.addTransition(fmt.Sprintf("%s %s->%s", getPartLabel(c, message.To), prev, message.Type))
+			c.Vars.Set(lastReceivedTypeKey(message.To), string(message.Type))
+			return
+		}
+
+		eType, ok := e.Type.(*types.GenericEventType)
+		if !ok || eType.T != "newStep" {
+			return
+		}
+		height, err := strconv.Atoi(eType.Params["height"])
+		if err != nil {
+			return
+		}
+		lock := getLockState(c, e.Replica, height)
+		cov.addState(fmt.Sprintf("%s h%s r%s %s locked%d valid%d",
+			getPartLabel(c, e.Replica), eType.Params["height"], eType.Params["round"], eType.Params["step"],
+			lock.lockedRound, lock.validRound))
+		return
+	}
+}
+
+// Corpus holds the instances that reached new protocol states, which coverage-guided fuzzing mutates
+type Corpus struct {
+	Instances []ByzzFuzzInstanceConfig
+	// Covered by all runs so far, including the runs of instances that were not kept
+	Coverage *Coverage
+}
+
+func NewCorpus() *Corpus {
+	return &Corpus{Coverage: NewCoverage()}
+}
+
+// Add merges the coverage of a run of the instance, and keeps the instance if the run covered anything new.
+// It returns how many states and transitions were new.
+func (c *Corpus) Add(inst ByzzFuzzInstanceConfig, cov *Coverage) int {
+	added := c.Coverage.Merge(cov)
+	if added > 0 {
+		c.Instances = append(c.Instances, inst.clone())
+	}
+	return added
+}
+
+// Mutate returns a mutation of a random instance of the corpus, which must not be empty
+func (c *Corpus) Mutate(r *rand.Rand, scope Scope, steps int) ByzzFuzzInstanceConfig {
+	return Mutate(c.Instances[r.Intn(len(c.Instances))], r, scope, steps)
+}
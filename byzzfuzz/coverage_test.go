@@ -0,0 +1,47 @@
+package byzzfuzz
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCorpusKeepsInstancesWithNewCoverage(t *testing.T) {
+	coverage := func(states ...string) *Coverage {
+		cov := NewCoverage()
+		for _, state := range states {
+			cov.addState(state)
+		}
+		return cov
+	}
+	corpus := NewCorpus()
+	for _, test := range []struct {
+		states []string
+		added  int
+	}{
+		{[]string{"node0 h1 r0 RoundStepPropose", "node1 h1 r0 RoundStepPropose"}, 2},
+		// Nothing new
+		{[]string{"node0 h1 r0 RoundStepPropose"}, 0},
+		{nil, 0},
+		{[]string{"node0 h1 r0 RoundStepPropose", "node0 h1 r1 RoundStepPropose"}, 1},
+	} {
+		inst := mutationTestInstance()
+		kept := len(corpus.Instances)
+		if added := corpus.Add(inst, coverage(test.states...)); added != test.added {
+			t.Errorf("%v: added %d states, want %d", test.states, added, test.added)
+		}
+		if wantKept := test.added > 0; (len(corpus.Instances) > kept) != wantKept {
+			t.Errorf("%v: corpus grew from %d to %d instances", test.states, kept, len(corpus.Instances))
+		}
+	}
+	if len(corpus.Instances) != 2 || corpus.Coverage.Size() != 3 {
+		t.Errorf("corpus of %d instances covering %d states, want 2 instances covering 3 states", len(corpus.Instances), corpus.Coverage.Size())
+	}
+
+	// Kept instances do not change with the instance they were added from
+	inst := mutationTestInstance()
+	corpus.Add(inst, coverage("node2 h1 r0 RoundStepPropose"))
+	inst.Drops[0].Partition[0][0] = 3
+	if kept := corpus.Instances[len(corpus.Instances)-1]; !reflect.DeepEqual(kept, mutationTestInstance()) {
+		t.Errorf("kept instance changed to %v", kept.Json())
+	}
+}
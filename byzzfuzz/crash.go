@@ -0,0 +1,183 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/byzzfuzz/spec"
+	"byzzfuzz/liveness"
+	"fmt"
+	"sort"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// NodeCrash stops Node once it reaches step Step, with steps as perceived by that node.
+// The node is started again once any node reaches RestartStep, or else when the network heals,
+// so that it takes part in the liveness phase as a correct node.
+type NodeCrash struct {
+	Step        int `json:"step"`
+	Node        int `json:"node"`
+	RestartStep int `json:"restart_step,omitempty"`
+}
+
+// NodeController stops and starts the nodes of the cluster under test
+type NodeController interface {
+	StopNode(replica *types.Replica) error
+	StartNode(replica *types.Replica) error
+}
+
+type crashState int
+
+const (
+	crashPending crashState = iota
+	crashDown
+	crashRestarted
+)
+
+func validateCrashes(n int, crashes []NodeCrash) error {
+	byNode := make(map[int][]NodeCrash)
+	for _, crash := range crashes {
+		if crash.Node < 0 || crash.Node >= n {
+			return fmt.Errorf("crash of node %d, which does not exist in a cluster of %d nodes", crash.Node, n)
+		}
+		if crash.Step < 0 {
+			return fmt.Errorf("crash of node %d at negative step %d", crash.Node, crash.Step)
+		}
+		if crash.RestartStep != 0 && crash.RestartStep <= crash.Step {
+			return fmt.Errorf("crash of node %d at step %d restarts at step %d, before it crashed", crash.Node, crash.Step, crash.RestartStep)
+		}
+		byNode[crash.Node] = append(byNode[crash.Node], crash)
+	}
+	for node, nodeCrashes := range byNode {
+		sort.Slice(nodeCrashes, func(i, j int) bool { return nodeCrashes[i].Step < nodeCrashes[j].Step })
+		for i := 1; i < len(nodeCrashes); i++ {
+			prev := nodeCrashes[i-1]
+			if prev.RestartStep == 0 || prev.RestartStep > nodeCrashes[i].Step {
+				return fmt.Errorf("crashes of node %d at steps %d and %d overlap", node, prev.Step, nodeCrashes[i].Step)
+			}
+		}
+	}
+	return nil
+}
+
+func crashStateKey(index int) string {
+	return fmt.Sprintf("BF_crash_%d", index)
+}
+
+func nodeStepKey(r types.ReplicaID) string {
+	return fmt.Sprintf("BF_node_step_%s", r)
+}
+
+// trackNodeSteps records the step every node is at, from the consensus messages it sends and the rounds it enters
+func trackNodeSteps(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+	var step int
+	if e.IsMessageSend() {
+		var ok bool
+		step, ok = messageStep(e, c)
+		if !ok {
+			return
+		}
+	} else if eType, ok := e.Type.(*types.GenericEventType); ok && eType.T == "newStep" {
+		totalRounds, ok := c.Vars.GetInt(totalRoundsKey(e.Replica))
+		if !ok {
+			return
+		}
+		step = 3 * totalRounds
+	} else {
+		return
+	}
+	if current, ok := c.Vars.GetInt(nodeStepKey(e.Replica)); !ok || step > current {
+		c.Vars.Set(nodeStepKey(e.Replica), step)
+	}
+	return
+}
+
+func nodeReplica(c *testlib.Context, index int) (*types.Replica, bool) {
+	partitionR, ok := c.Vars.Get("partition")
+	if !ok {
+		return nil, false
+	}
+	part, ok := partitionR.(*util.Partition).GetPart(nodeLabel(index))
+	if !ok || len(part.ReplicaSet.Iter()) == 0 {
+		return nil, false
+	}
+	return c.Replicas.Get(part.ReplicaSet.Iter()[0])
+}
+
+// crashNodes stops and restarts the nodes of the crashes, and drops the messages from and to nodes that are down.
+// Crashed nodes are forgotten by the round skip monitor, since they cannot follow the rule while down.
+func crashNodes(crashes []NodeCrash, nodes NodeController, roundSkips *spec.RoundSkipMonitor) testlib.FilterFunc {
+	return func(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+		healed := liveness.IsTestFinished(e, c)
+		down := make(map[string]bool)
+		for i, crash := range crashes {
+			key := crashStateKey(i)
+			state := crashPending
+			if s, ok := c.Vars.GetInt(key); ok {
+				state = crashState(s)
+			}
+			replica, ok := nodeReplica(c, crash.Node)
+			if !ok {
+				continue
+			}
+			label := nodeLabel(crash.Node)
+
+			if state == crashPending && !healed {
+				step, ok := c.Vars.GetInt(nodeStepKey(replica.ID))
+				if ok && step >= crash.Step {
+					state = crashDown
+					c.Vars.Set(key, int(state))
+					roundSkips.Forget(label)
+					c.Logger().With(log.LogParams{
+						"node": label,
+						"step": step,
+					}).Info(crashNodeLog)
+					go controlNode(c, nodes.StopNode, replica, "stop")
+				}
+			}
+			if state == crashDown && (healed || (crash.RestartStep > 0 && currentStep(c) >= crash.RestartStep)) {
+				state = crashRestarted
+				c.Vars.Set(key, int(state))
+				roundSkips.Forget(label)
+				c.Logger().With(log.LogParams{
+					"node": label,
+					"step": currentStep(c),
+				}).Info(restartNodeLog)
+				go controlNode(c, nodes.StartNode, replica, "start")
+			}
+			if state == crashDown {
+				down[label] = true
+			}
+		}
+
+		if len(down) == 0 || !e.IsMessageSend() {
+			return
+		}
+		message, ok := util.GetMessageFromEvent(e, c)
+		if !ok {
+			return
+		}
+		if down[getPartLabel(c, message.From)] || down[getPartLabel(c, message.To)] {
+			return []*types.Message{}, true
+		}
+		return
+	}
+}
+
+// Logged with the label of the node, replays of the log of a run forget about the node there
+const (
+	crashNodeLog   = "Crashing node"
+	restartNodeLog = "Restarting node"
+)
+
+// controlNode runs outside of the event loop, stopping a container takes a while
+func controlNode(c *testlib.Context, control func(*types.Replica) error, replica *types.Replica, action string) {
+	err := control(replica)
+	if err != nil {
+		c.Logger().With(log.LogParams{
+			"replica": replica.ID,
+			"error":   err.Error(),
+		}).Error("Failed to " + action + " node")
+	}
+}
@@ -0,0 +1,343 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/liveness"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/common"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// MessageDelay holds the messages of a step that cross the partition, instead of dropping them.
+// They are released once Steps more steps have been reached, or once Duration has passed, whichever comes first.
+type MessageDelay struct {
+	Step      int           `json:"step"`
+	Partition Partition     `json:"partition"`
+	Steps     int           `json:"steps,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+}
+
+func (d *MessageDelay) MessageType() util.MessageType {
+	switch d.Step % 3 {
+	case 0:
+		return util.Proposal
+	case 1:
+		return util.Prevote
+	case 2:
+		return util.Precommit
+	default:
+		panic("impossible")
+	}
+}
+
+func (d *MessageDelay) Round() int {
+	return d.Step / 3
+}
+
+// MessageReorder holds the consensus messages sent over the link From -> To during steps [Step, Step+Steps),
+// and then releases them all at once, shuffled by Seed.
+type MessageReorder struct {
+	Step  int   `json:"step"`
+	From  int   `json:"from_node"`
+	To    int   `json:"to_node"`
+	Steps int   `json:"steps"`
+	Seed  int64 `json:"seed"`
+}
+
+// Upper bound on the number of steps a random delay or reorder holds messages for
+const maxHoldSteps = 3
+
+func randomDelay(sp *common.SystemParams, r *rand.Rand, steps int) MessageDelay {
+	return MessageDelay{
+		Step:      r.Intn(steps),
+		Partition: RandomPartition(sp, r),
+		Steps:     1 + r.Intn(maxHoldSteps),
+	}
+}
+
+func randomReorder(sp *common.SystemParams, r *rand.Rand, steps int) MessageReorder {
+	link := r.Perm(sp.N)
+	return MessageReorder{
+		Step:  r.Intn(steps),
+		From:  link[0],
+		To:    link[1],
+		Steps: 1 + r.Intn(maxHoldSteps),
+		Seed:  r.Int63(),
+	}
+}
+
+func validateDelays(sp *common.SystemParams, delays []MessageDelay, reorders []MessageReorder) error {
+	for _, delay := range delays {
+		if delay.Steps <= 0 && delay.Duration <= 0 {
+			return fmt.Errorf("delay at step %d is never released, set steps or duration", delay.Step)
+		}
+		if err := validatePartition(sp.N, delay.Partition); err != nil {
+			return fmt.Errorf("delay at step %d: %s", delay.Step, err)
+		}
+	}
+	for _, reorder := range reorders {
+		if reorder.Steps <= 0 {
+			return fmt.Errorf("reorder at step %d must span at least one step", reorder.Step)
+		}
+		if reorder.From == reorder.To {
+			return fmt.Errorf("reorder at step %d is on a link from node %d to itself", reorder.Step, reorder.From)
+		}
+		for _, node := range []int{reorder.From, reorder.To} {
+			if node < 0 || node >= sp.N {
+				return fmt.Errorf("reorder of messages on a link with node %d, which does not exist in a cluster of %d nodes", node, sp.N)
+			}
+		}
+	}
+	return nil
+}
+
+const currentStepKey = "BF_current_step"
+const heldMessagesKey = "BF_held_messages"
+
+// heldMessages are the messages that delays and reorders have taken out of the network
+type heldMessages struct {
+	delayed []delayedMessage
+	// Indexed by reorder
+	reordered map[int][]*types.Message
+}
+
+type delayedMessage struct {
+	message     *types.Message
+	releaseStep int
+	// Zero if the delay is only bounded in steps
+	releaseAt time.Time
+}
+
+func getHeldMessages(c *testlib.Context) *heldMessages {
+	held, ok := c.Vars.Get(heldMessagesKey)
+	if !ok {
+		held = &heldMessages{reordered: make(map[int][]*types.Message)}
+		c.Vars.Set(heldMessagesKey, held)
+	}
+	return held.(*heldMessages)
+}
+
+// remove takes a message out of the held messages, and returns whether it was held
+func (h *heldMessages) remove(id string) bool {
+	for i, delayed := range h.delayed {
+		if delayed.message.ID == id {
+			h.delayed = append(h.delayed[:i], h.delayed[i+1:]...)
+			return true
+		}
+	}
+	for index, pending := range h.reordered {
+		for i, message := range pending {
+			if message.ID == id {
+				h.reordered[index] = append(pending[:i], pending[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// holds tells whether a message is held
+func (h *heldMessages) holds(id string) bool {
+	for _, delayed := range h.delayed {
+		if delayed.message.ID == id {
+			return true
+		}
+	}
+	for _, pending := range h.reordered {
+		for _, message := range pending {
+			if message.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// messageStep returns the step of a sent consensus message, with rounds as perceived by the sender
+func messageStep(e *types.Event, c *testlib.Context) (int, bool) {
+	message, ok := util.GetMessageFromEvent(e, c)
+	if !ok || message.Round() == -1 {
+		return 0, false
+	}
+	var offset int
+	switch message.Type {
+	case util.Proposal:
+		offset = 0
+	case util.Prevote:
+		offset = 1
+	case util.Precommit:
+		offset = 2
+	default:
+		return 0, false
+	}
+	totalRounds, ok := c.Vars.GetInt(totalRoundForHeightRoundKey(e.Replica, message.Height(), message.Round()))
+	if !ok {
+		return 0, false
+	}
+	return 3*totalRounds + offset, true
+}
+
+// trackCurrentStep records the highest step any node has sent a consensus message for
+func trackCurrentStep(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+	if !e.IsMessageSend() {
+		return
+	}
+	step, ok := messageStep(e, c)
+	if !ok {
+		return
+	}
+	current, ok := c.Vars.GetInt(currentStepKey)
+	if !ok || step > current {
+		c.Vars.Set(currentStepKey, step)
+	}
+	return
+}
+
+func currentStep(c *testlib.Context) int {
+	step, ok := c.Vars.GetInt(currentStepKey)
+	if !ok {
+		return 0
+	}
+	return step
+}
+
+func isMessageInSteps(from int, to int) testlib.Condition {
+	return func(e *types.Event, c *testlib.Context) bool {
+		if liveness.IsTestFinished(e, c) {
+			return false
+		}
+		step, ok := messageStep(e, c)
+		return ok && step >= from && step < to
+	}
+}
+
+// WakeEvent is the type of the events that wake the filters once held messages are due, see InstanceOptions.Wake
+const WakeEvent = "Wake"
+
+// Time after a held message is due at which its receiver is woken
+const wakeMargin = 100 * time.Millisecond
+
+// Action holds the message of the event until the delay releases it. Messages held for a duration wake their
+// receiver once they are due, if wake is set.
+func (d *MessageDelay) Action(wake func(replica types.ReplicaID)) testlib.Action {
+	return func(e *types.Event, c *testlib.Context) []*types.Message {
+		message, ok := c.GetMessage(e)
+		if !ok {
+			return []*types.Message{}
+		}
+		m, ok := util.GetParsedMessage(message)
+		if !ok {
+			return []*types.Message{}
+		}
+		delayed := delayedMessage{
+			message:     message,
+			releaseStep: d.Step + d.Steps,
+		}
+		if d.Steps <= 0 {
+			// Only released by time, or once the network heals
+			delayed.releaseStep = -1
+		}
+		if d.Duration > 0 {
+			delayed.releaseAt = time.Now().Add(d.Duration)
+			if wake != nil {
+				// The wake event takes a moment to arrive, just after the message is due
+				time.AfterFunc(d.Duration+wakeMargin, func() { wake(message.To) })
+			}
+		}
+		held := getHeldMessages(c)
+		held.delayed = append(held.delayed, delayed)
+		c.Logger().With(log.LogParams{
+			"from":         getPartLabel(c, m.From),
+			"to":           getPartLabel(c, m.To),
+			"type":         m.Type,
+			"height":       m.Height(),
+			"round":        m.Round(),
+			"release_step": delayed.releaseStep,
+			"duration":     d.Duration.String(),
+		}).Debug("Delaying message")
+		return []*types.Message{}
+	}
+}
+
+func reorderAction(index int) testlib.Action {
+	return func(e *types.Event, c *testlib.Context) []*types.Message {
+		message, ok := c.GetMessage(e)
+		if !ok {
+			return []*types.Message{}
+		}
+		m, ok := util.GetParsedMessage(message)
+		if !ok {
+			return []*types.Message{}
+		}
+		held := getHeldMessages(c)
+		held.reordered[index] = append(held.reordered[index], message)
+		c.Logger().With(log.LogParams{
+			"from":   getPartLabel(c, m.From),
+			"to":     getPartLabel(c, m.To),
+			"type":   m.Type,
+			"height": m.Height(),
+			"round":  m.Round(),
+		}).Debug("Holding message for reordering")
+		return []*types.Message{}
+	}
+}
+
+// releaseHeldMessages delivers the held messages whose release condition holds, together with the message of the
+// current event, if any. All messages are released once the test is finished and the network heals.
+// It should be the last filter, so that it only sees events that no fault has handled.
+func releaseHeldMessages(reorders []MessageReorder) testlib.FilterFunc {
+	return func(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+		held := getHeldMessages(c)
+		step := currentStep(c)
+		healed := liveness.IsTestFinished(e, c)
+		now := time.Now()
+
+		stillDelayed := make([]delayedMessage, 0, len(held.delayed))
+		for _, delayed := range held.delayed {
+			byStep := delayed.releaseStep >= 0 && step >= delayed.releaseStep
+			byTime := !delayed.releaseAt.IsZero() && !now.Before(delayed.releaseAt)
+			if !(healed || byStep || byTime) {
+				stillDelayed = append(stillDelayed, delayed)
+				continue
+			}
+			c.Logger().With(log.LogParams{
+				"message_id": delayed.message.ID,
+				"step":       step,
+			}).Debug("Releasing delayed message")
+			messages = append(messages, delayed.message)
+		}
+		held.delayed = stillDelayed
+
+		for i, reorder := range reorders {
+			pending := held.reordered[i]
+			if len(pending) == 0 || !(healed || step >= reorder.Step+reorder.Steps) {
+				continue
+			}
+			r := rand.New(rand.NewSource(reorder.Seed))
+			r.Shuffle(len(pending), func(a, b int) {
+				pending[a], pending[b] = pending[b], pending[a]
+			})
+			order := make([]string, len(pending))
+			for j, m := range pending {
+				order[j] = m.ID
+			}
+			c.Logger().With(log.LogParams{
+				"from":  nodeLabel(reorder.From),
+				"to":    nodeLabel(reorder.To),
+				"order": order,
+			}).Debug("Releasing reordered messages")
+			messages = append(messages, pending...)
+			delete(held.reordered, i)
+		}
+
+		if len(messages) == 0 {
+			return
+		}
+		return append(messages, testlib.DeliverMessage()(e, c)...), true
+	}
+}
@@ -0,0 +1,45 @@
+package byzzfuzz
+
+import (
+	"testing"
+	"time"
+
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/common"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+func TestValidateRejectsPartitionsThatLeaveOutNodes(t *testing.T) {
+	partial := Partition{{0}, {1, 2}}
+	for name, inst := range map[string]ByzzFuzzInstanceConfig{
+		"drop":  {sysParams: common.NewSystemParams(4), Drops: []MessageDrop{{Step: 1, Partition: partial}}},
+		"delay": {sysParams: common.NewSystemParams(4), Delays: []MessageDelay{{Step: 1, Steps: 1, Partition: partial}}},
+	} {
+		if err := inst.Validate(); err == nil {
+			t.Errorf("%s partition %v that leaves out node 3 is valid", name, partial)
+		}
+	}
+}
+
+func TestDelayWakesTheReceiverOnceDue(t *testing.T) {
+	s := newSequencerTest(t)
+	message := s.message(0, 1, util.Prevote)
+	e := types.NewEvent(message.From, types.NewMessageSendEventType(message.ID), "", types.EventID(s.events), int64(s.events))
+
+	woken := make(chan types.ReplicaID, 1)
+	delay := MessageDelay{Step: 0, Duration: time.Millisecond, Partition: Partition{{0}, {1, 2, 3}}}
+	if delivered := delay.Action(func(replica types.ReplicaID) { woken <- replica })(e, s.ctx); len(delivered) != 0 {
+		t.Fatalf("delivered %d messages, want the message held", len(delivered))
+	}
+	if !getHeldMessages(s.ctx).holds(message.ID) {
+		t.Fatal("message is not held")
+	}
+	select {
+	case replica := <-woken:
+		if replica != message.To {
+			t.Errorf("woke %s, want %s", replica, message.To)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("receiver of the delayed message was not woken")
+	}
+}
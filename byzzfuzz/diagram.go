@@ -43,7 +43,9 @@ func logConsensusMessages(e *types.Event, c *testlib.Context) (messages []*types
 		"type":       message.Type,
 		"height":     message.Height(),
 		"round":      message.Round(),
-	}).Info("Consensus message")
+	}).Info(consensusMessageLog)
 
 	return
 }
+
+const consensusMessageLog = "Consensus message"
@@ -0,0 +1,248 @@
+package byzzfuzz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+	tmsg "github.com/tendermint/tendermint/proto/tendermint/consensus"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// IsEquivocation is true for corruptions that send conflicting messages to the nodes in To and to the other nodes.
+// Their filters match the messages to all nodes, the action decides which version a recipient gets.
+func (c *MessageCorruption) IsEquivocation() bool {
+	switch c.Corruption {
+	case EquivocateVote, EquivocateVoteNil, EquivocateProposal:
+		return true
+	default:
+		return false
+	}
+}
+
+// equivocateVote sends the original vote to the nodes outside of to, and a re-signed vote for another block
+// (or nil) to the nodes in to.
+func equivocateVote(to []int, seed int, toNil bool) testlib.Action {
+	return func(e *types.Event, c *testlib.Context) []*types.Message {
+		m, ok := c.GetMessage(e)
+		if !ok {
+			return []*types.Message{}
+		}
+		tMsg, ok := util.GetParsedMessage(m)
+		if !ok {
+			return []*types.Message{m}
+		}
+		if tMsg.Type != util.Precommit && tMsg.Type != util.Prevote {
+			return []*types.Message{m}
+		}
+		corruptionType := "EquivocateVote"
+		if toNil {
+			corruptionType = "EquivocateVoteNil"
+		}
+		if !isToOneOf(c, tMsg.To, to) {
+			logEquivocation(c, tMsg, corruptionType, "original")
+			return []*types.Message{m}
+		}
+		replica, ok := findVoteSigner(c, tMsg)
+		if !ok || replica.ID != tMsg.From {
+			// Votes of other nodes that the sender forwards cannot be forged, the faulty node lacks their keys
+			return []*types.Message{m}
+		}
+
+		blockID := &ttypes.BlockID{}
+		if !toNil {
+			original, ok := util.GetVoteBlockID(tMsg)
+			if !ok {
+				return []*types.Message{m}
+			}
+			blockID = equivocationBlockID(c, tMsg, original, seed)
+		}
+		// ChangeVote rewrites the message in place, keep the original intact in the message pool
+		changed := *tMsg
+		newVote, err := util.ChangeVote(replica, &changed, blockID)
+		if err != nil {
+			return []*types.Message{m}
+		}
+		msgB, err := newVote.Marshal()
+		if err != nil {
+			return []*types.Message{m}
+		}
+		logEquivocation(c, tMsg, corruptionType, blockID.String())
+		return []*types.Message{c.NewMessage(m, msgB)}
+	}
+}
+
+// equivocateProposal sends the original proposal to the nodes outside of to, and a re-signed proposal
+// for another block to the nodes in to.
+func equivocateProposal(to []int, seed int) testlib.Action {
+	return func(e *types.Event, c *testlib.Context) []*types.Message {
+		m, ok := c.GetMessage(e)
+		if !ok {
+			return []*types.Message{}
+		}
+		tMsg, ok := util.GetParsedMessage(m)
+		if !ok {
+			return []*types.Message{m}
+		}
+		if tMsg.Type != util.Proposal {
+			return []*types.Message{m}
+		}
+		if !isToOneOf(c, tMsg.To, to) {
+			logEquivocation(c, tMsg, "EquivocateProposal", "original")
+			return []*types.Message{m}
+		}
+		replica, ok := c.Replicas.Get(tMsg.From)
+		if !ok || !isSignedBy(replica, tMsg) {
+			// Proposals of other nodes that the sender forwards cannot be forged, the faulty node lacks their keys
+			return []*types.Message{m}
+		}
+		original, ok := util.GetProposalBlockID(tMsg)
+		if !ok {
+			return []*types.Message{m}
+		}
+		blockID := equivocationBlockID(c, tMsg, original, seed)
+		newProp, err := changeProposalBlockID(replica, tMsg, blockID)
+		if err != nil {
+			return []*types.Message{m}
+		}
+		msgB, err := newProp.Marshal()
+		if err != nil {
+			return []*types.Message{m}
+		}
+		logEquivocation(c, tMsg, "EquivocateProposal", blockID.String())
+		return []*types.Message{c.NewMessage(m, msgB)}
+	}
+}
+
+func logEquivocation(c *testlib.Context, tMsg *util.TMessage, corruptionType string, blockID string) {
+	c.Logger().With(log.LogParams{
+		"height":   tMsg.Height(),
+		"round":    tMsg.Round(),
+		"from":     getPartLabel(c, tMsg.From),
+		"to":       getPartLabel(c, tMsg.To),
+		"type":     corruptionType,
+		"block_id": blockID,
+	}).Info(corruptionLog)
+}
+
+func isToOneOf(c *testlib.Context, to types.ReplicaID, replicaIdxs []int) bool {
+	label := getPartLabel(c, to)
+	for _, idx := range replicaIdxs {
+		if label == nodeLabel(idx) {
+			return true
+		}
+	}
+	return false
+}
+
+// findVoteSigner returns the replica whose key signed the vote, so that the changed vote can be re-signed
+func findVoteSigner(c *testlib.Context, tMsg *util.TMessage) (*types.Replica, bool) {
+	valAddr, ok := util.GetVoteValidator(tMsg)
+	if !ok {
+		return nil, false
+	}
+	for _, r := range c.Replicas.Iter() {
+		addr, err := util.GetReplicaAddress(r)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(addr, valAddr) {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// isSignedBy checks that the proposal was signed by the replica
+func isSignedBy(replica *types.Replica, pMsg *util.TMessage) bool {
+	privKey, err := util.GetPrivKey(replica)
+	if err != nil {
+		return false
+	}
+	chainID, err := util.GetChainID(replica)
+	if err != nil {
+		return false
+	}
+	propP := pMsg.Data.GetProposal().Proposal
+	return privKey.PubKey().VerifySignature(ttypes.ProposalSignBytes(chainID, &propP), propP.Signature)
+}
+
+// equivocationBlockID picks the conflicting block for a message. All recipients of the conflicting messages
+// of a sender in the same height, round and step get the same block.
+// It prefers a block that was proposed before, selected by the seed, and otherwise makes one up.
+func equivocationBlockID(c *testlib.Context, tMsg *util.TMessage, original *ttypes.BlockID, seed int) *ttypes.BlockID {
+	key := fmt.Sprintf("BF_equivocation_%s_%d_%d_%s", tMsg.From, tMsg.Height(), tMsg.Round(), tMsg.Type)
+	if blockIDR, ok := c.Vars.Get(key); ok {
+		return blockIDR.(*ttypes.BlockID)
+	}
+
+	candidates := make([]*ttypes.BlockID, 0)
+	if blockIdsR, ok := c.Vars.Get("BF_blockids"); ok {
+		for _, blockID := range blockIdsR.([]*ttypes.BlockID) {
+			if len(blockID.Hash) > 0 && !bytes.Equal(blockID.Hash, original.Hash) {
+				candidates = append(candidates, blockID)
+			}
+		}
+	}
+	var blockID *ttypes.BlockID
+	if len(candidates) > 0 {
+		blockID = candidates[seed%len(candidates)]
+	} else {
+		seedB := make([]byte, 8)
+		binary.BigEndian.PutUint64(seedB, uint64(seed))
+		hash := sha256.Sum256(append(append([]byte{}, original.Hash...), seedB...))
+		partsHash := sha256.Sum256(hash[:])
+		blockID = &ttypes.BlockID{
+			Hash:          hash[:],
+			PartSetHeader: ttypes.PartSetHeader{Total: 1, Hash: partsHash[:]},
+		}
+	}
+	c.Vars.Set(key, blockID)
+	return blockID
+}
+
+// changeProposalBlockID re-signs the proposal for another block, like util.ChangeProposalBlockIDToNil does for nil.
+// Unlike the util functions, it leaves pMsg unchanged.
+func changeProposalBlockID(replica *types.Replica, pMsg *util.TMessage, blockID *ttypes.BlockID) (*util.TMessage, error) {
+	privKey, err := util.GetPrivKey(replica)
+	if err != nil {
+		return nil, err
+	}
+	chainID, err := util.GetChainID(replica)
+	if err != nil {
+		return nil, err
+	}
+	propP := pMsg.Data.GetProposal().Proposal
+	prop, err := ttypes.ProposalFromProto(&propP)
+	if err != nil {
+		return nil, fmt.Errorf("failed converting proposal message: %s", err)
+	}
+	newProp := &ttypes.Proposal{
+		Type:      prop.Type,
+		Height:    prop.Height,
+		Round:     prop.Round,
+		POLRound:  prop.POLRound,
+		BlockID:   *blockID,
+		Timestamp: prop.Timestamp,
+	}
+	sig, err := privKey.Sign(ttypes.ProposalSignBytes(chainID, newProp.ToProto()))
+	if err != nil {
+		return nil, fmt.Errorf("could not sign proposal: %s", err)
+	}
+	newProp.Signature = sig
+
+	changed := *pMsg
+	changed.Data = &tmsg.Message{
+		Sum: &tmsg.Message_Proposal{
+			Proposal: &tmsg.Proposal{
+				Proposal: *newProp.ToProto(),
+			},
+		},
+	}
+	return &changed, nil
+}
@@ -0,0 +1,166 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+	tmsg "github.com/tendermint/tendermint/proto/tendermint/consensus"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// Messages that Tendermint gossips besides proposals and votes. Drops and corruptions with a GossipType
+// target these instead of the consensus message of their step, in the round of their step.
+var GossipMessageTypes = []util.MessageType{
+	util.BlockPart,
+	util.NewRoundStep,
+	util.HasVote,
+	util.VoteSetMaj23,
+}
+
+var GossipCorruptionTypes = map[util.MessageType][]CorruptionType{
+	util.BlockPart:    {TruncateBlockPart, Omit},
+	util.NewRoundStep: {ChangeRoundStep, Omit},
+	util.HasVote:      {LieHasVote, Omit},
+	util.VoteSetMaj23: {LieVoteSetMaj23, Omit},
+}
+
+func isGossipMessageType(t util.MessageType) bool {
+	_, ok := GossipCorruptionTypes[t]
+	return ok
+}
+
+func isGossipCorruption(corruption CorruptionType) bool {
+	switch corruption {
+	case TruncateBlockPart, ChangeRoundStep, LieHasVote, LieVoteSetMaj23:
+		return true
+	default:
+		return false
+	}
+}
+
+// Half of the drops and corruptions in gossip scope target gossip messages
+func randomGossipType(r *rand.Rand) util.MessageType {
+	if r.Intn(2) == 0 {
+		return ""
+	}
+	return GossipMessageTypes[r.Intn(len(GossipMessageTypes))]
+}
+
+func randomGossipCorruption(r *rand.Rand, gossipType util.MessageType) CorruptionType {
+	corruptionTypes := GossipCorruptionTypes[gossipType]
+	return corruptionTypes[r.Intn(len(corruptionTypes))]
+}
+
+func validateGossip(drops []MessageDrop, corruptions []MessageCorruption) error {
+	for _, drop := range drops {
+		if drop.GossipType != "" && !isGossipMessageType(drop.GossipType) {
+			return fmt.Errorf("drop at step %d targets %s messages, which are not gossip messages", drop.Step, drop.GossipType)
+		}
+	}
+	for _, corruption := range corruptions {
+		if corruption.GossipType == "" {
+			if isGossipCorruption(corruption.Corruption) {
+				return fmt.Errorf("corruption at step %d only applies to gossip messages, but has no message type", corruption.Step)
+			}
+			continue
+		}
+		valid := false
+		for _, t := range GossipCorruptionTypes[corruption.GossipType] {
+			valid = valid || t == corruption.Corruption
+		}
+		if !valid {
+			return fmt.Errorf("corruption type %d at step %d does not apply to %s messages", corruption.Corruption, corruption.Step, corruption.GossipType)
+		}
+	}
+	return nil
+}
+
+// messageHeightRound is like HeightRound of the message, but also knows the round of HasVote messages
+func messageHeightRound(m *util.TMessage) (int, int) {
+	if m.Type == util.HasVote {
+		hasVote := m.Data.GetHasVote()
+		return int(hasVote.Height), int(hasVote.Round)
+	}
+	return m.HeightRound()
+}
+
+// changeGossip returns an action that replaces the gossip message of the given type by the result of change.
+// change returns false if it cannot corrupt the message, which is then delivered unchanged.
+func changeGossip(messageType util.MessageType, name string, change func(*testlib.Context, *util.TMessage) (*tmsg.Message, bool)) testlib.Action {
+	return func(e *types.Event, c *testlib.Context) []*types.Message {
+		m, ok := c.GetMessage(e)
+		if !ok {
+			return []*types.Message{}
+		}
+		tMsg, ok := util.GetParsedMessage(m)
+		if !ok || tMsg.Type != messageType {
+			return []*types.Message{m}
+		}
+		data, ok := change(c, tMsg)
+		if !ok {
+			return []*types.Message{m}
+		}
+		changed := *tMsg
+		changed.Data = data
+		msgB, err := changed.Marshal()
+		if err != nil {
+			return []*types.Message{m}
+		}
+		height, round := messageHeightRound(tMsg)
+		c.Logger().With(log.LogParams{
+			"height": height,
+			"round":  round,
+			"from":   getPartLabel(c, tMsg.From),
+			"to":     getPartLabel(c, tMsg.To),
+			"type":   name,
+		}).Info(corruptionLog)
+		return []*types.Message{c.NewMessage(m, msgB)}
+	}
+}
+
+// truncateBlockPart cuts a block part in half, so that it no longer matches its proof
+func truncateBlockPart(c *testlib.Context, tMsg *util.TMessage) (*tmsg.Message, bool) {
+	blockPart := *tMsg.Data.GetBlockPart()
+	if len(blockPart.Part.Bytes) == 0 {
+		return nil, false
+	}
+	blockPart.Part.Bytes = blockPart.Part.Bytes[:len(blockPart.Part.Bytes)/2]
+	return &tmsg.Message{Sum: &tmsg.Message_BlockPart{BlockPart: &blockPart}}, true
+}
+
+// changeRoundStep claims that the sender is a round ahead of where it is
+func changeRoundStep(c *testlib.Context, tMsg *util.TMessage) (*tmsg.Message, bool) {
+	newRoundStep := *tMsg.Data.GetNewRoundStep()
+	newRoundStep.Round++
+	return &tmsg.Message{Sum: &tmsg.Message_NewRoundStep{NewRoundStep: &newRoundStep}}, true
+}
+
+// lieHasVote claims that the sender has the vote of another validator, so that the receiver does not send it
+func lieHasVote(seed int) func(*testlib.Context, *util.TMessage) (*tmsg.Message, bool) {
+	return func(c *testlib.Context, tMsg *util.TMessage) (*tmsg.Message, bool) {
+		n := int32(len(c.Replicas.Iter()))
+		if n < 2 {
+			return nil, false
+		}
+		hasVote := *tMsg.Data.GetHasVote()
+		hasVote.Index = (hasVote.Index + 1 + int32(seed)%(n-1)) % n
+		return &tmsg.Message{Sum: &tmsg.Message_HasVote{HasVote: &hasVote}}, true
+	}
+}
+
+// lieVoteSetMaj23 claims a two-thirds majority for another block, selected like the blocks of equivocations
+func lieVoteSetMaj23(seed int) func(*testlib.Context, *util.TMessage) (*tmsg.Message, bool) {
+	return func(c *testlib.Context, tMsg *util.TMessage) (*tmsg.Message, bool) {
+		voteSetMaj23 := *tMsg.Data.GetVoteSetMaj23()
+		original, err := ttypes.BlockIDFromProto(&voteSetMaj23.BlockID)
+		if err != nil {
+			return nil, false
+		}
+		voteSetMaj23.BlockID = equivocationBlockID(c, tMsg, original, seed).ToProto()
+		return &tmsg.Message{Sum: &tmsg.Message_VoteSetMaj23{VoteSetMaj23: &voteSetMaj23}}, true
+	}
+}
@@ -17,9 +17,14 @@ import (
 type MessageDrop struct {
 	Step      int       `json:"step"`
 	Partition Partition `json:"partition"`
+	// If set, drop gossip messages of this type instead of the consensus message of the step
+	GossipType util.MessageType `json:"message_type,omitempty"`
 }
 
 func (d *MessageDrop) MessageType() util.MessageType {
+	if d.GossipType != "" {
+		return d.GossipType
+	}
 	switch d.Step % 3 {
 	case 0:
 		return util.Proposal
@@ -42,9 +47,14 @@ type MessageCorruption struct {
 	To         []int          `json:"to_nodes"`
 	Corruption CorruptionType `json:"corruption_type"`
 	Seed       int            `json:"seed"`
+	// If set, corrupt gossip messages of this type instead of the consensus message of the step
+	GossipType util.MessageType `json:"message_type,omitempty"`
 }
 
 func (c *MessageCorruption) MessageType() util.MessageType {
+	if c.GossipType != "" {
+		return c.GossipType
+	}
 	switch c.Step % 3 {
 	case 0:
 		return util.Proposal
@@ -70,6 +80,15 @@ const (
 	Omit
 	ChangeVoteRoundAnyScope
 	ChangeBlockIdAnyScope
+	// Conflicting messages to the nodes in To and to the other nodes
+	EquivocateVote
+	EquivocateVoteNil
+	EquivocateProposal
+	// Corruptions of gossip messages
+	TruncateBlockPart
+	ChangeRoundStep
+	LieHasVote
+	LieVoteSetMaj23
 )
 
 var ProposalCorruptionTypes = []CorruptionType{
@@ -83,33 +102,119 @@ var VoteCorruptionTypes = []CorruptionType{
 	Omit,
 }
 
+var ProposalCorruptionTypesAnyScope = []CorruptionType{
+	ChangeBlockIdAnyScope,
+	Omit,
+}
+
+var VoteCorruptionTypesAnyScope = []CorruptionType{
+	ChangeVoteRoundAnyScope,
+	Omit,
+}
+
+var ProposalCorruptionTypesEquivocation = []CorruptionType{
+	ChangeBlockIdAnyScope,
+	EquivocateProposal,
+	Omit,
+}
+
+var VoteCorruptionTypesEquivocation = []CorruptionType{
+	ChangeVoteRoundAnyScope,
+	EquivocateVote,
+	EquivocateVoteNil,
+	Omit,
+}
+
+// Scope selects the set of corruptions that random instances are drawn from.
+type Scope string
+
+const (
+	// Corruptions that depend only on the corrupted message itself
+	SmallScope Scope = "small"
+	// Corruptions that may use any message seen so far, selected by the corruption seed
+	AnyScope Scope = "any"
+	// Any-scope corruptions, and corruptions that send conflicting messages to different nodes
+	EquivocationScope Scope = "equivocation"
+	// Any-scope corruptions, and drops and corruptions of gossip messages
+	GossipScope Scope = "gossip"
+)
+
+func ParseScope(s string) (Scope, error) {
+	switch Scope(s) {
+	case SmallScope, AnyScope, EquivocationScope, GossipScope:
+		return Scope(s), nil
+	default:
+		return "", fmt.Errorf("unknown scope '%s', expected small, any, equivocation or gossip", s)
+	}
+}
+
 const maxHeight = 3
 
 const DiffCommitsLabel = "diff-commits"
 
-func ByzzFuzzInst(
-	sp *common.SystemParams,
-	drops []MessageDrop,
-	corruptions []MessageCorruption,
-	timeout time.Duration,
-	livenessTimeout time.Duration) (*testlib.TestCase, chan spec.Event) {
+const InvalidCommitLabel = "invalid-commit"
 
-	sm := testlib.NewStateMachine()
-	init := sm.Builder()
-	init.On(spec.DiffCommits, DiffCommitsLabel)
-	init.On(common.HeightReached(maxHeight), testlib.SuccessStateLabel)
-	init.On(common.IsCommit().And(liveness.IsTestFinished), testlib.SuccessStateLabel)
+const DoubleCommitLabel = "double-commit"
+
+const RoundSkipViolationLabel = "round-skip-violation"
+
+// Time a replica has to move to a higher round once the round skip rule applies
+const roundSkipGracePeriod = 10 * time.Second
+
+// InstanceOptions are the faults of a test case built by ByzzFuzzInst, and what it records of the run.
+// Fields other than SysParams and the timeouts may be left out.
+type InstanceOptions struct {
+	SysParams   *common.SystemParams
+	Drops       []MessageDrop
+	Corruptions []MessageCorruption
+	Delays      []MessageDelay
+	Reorders    []MessageReorder
+	Crashes     []NodeCrash
+	Faulty      []int
+	// Crashes stop and start nodes through Nodes
+	Nodes NodeController
+	// If set, the protocol states of the run are recorded in Coverage
+	Coverage *Coverage
+	// If set, the order of the message events of the run is recorded in Record
+	Record *Schedule
+	// If set, consensus messages are delivered in the order of the run that Replay was recorded in,
+	// and the decisions of the filters in that run are replayed
+	Replay *Schedule
+	// Wake makes the testing server run the filters on an event of the replica, so that messages held for a
+	// duration, or by a replay, are released while the nodes are silent. Without Wake, they are only released on
+	// the events of the nodes.
+	Wake func(replica types.ReplicaID)
+	// The faults apply for Timeout, after which the network heals for LivenessTimeout
+	Timeout         time.Duration
+	LivenessTimeout time.Duration
+}
+
+func ByzzFuzzInst(opts InstanceOptions) (*testlib.TestCase, chan spec.Event) {
+	roundSkips := spec.NewRoundSkipMonitor(opts.SysParams.F, roundSkipGracePeriod)
+	sm := oracles(opts.Faulty, roundSkips.Condition())
 
 	filters := testlib.NewFilterSet()
+	// First, so that the event that ends the test is logged as well
+	filters.AddFilter(logReplicaEvents)
 	filters.AddFilter(testlib.If(sm.InState(testlib.SuccessStateLabel)).Then(endTest))
 	filters.AddFilter(trackTotalRounds)
+	filters.AddFilter(trackCurrentStep)
+	filters.AddFilter(trackPeerRounds)
+	if opts.Coverage != nil {
+		filters.AddFilter(recordCoverage(opts.Coverage))
+	}
+	if len(opts.Crashes) > 0 {
+		filters.AddFilter(trackNodeSteps)
+		// Before the spec log, which should not see the messages of nodes that are down
+		filters.AddFilter(crashNodes(opts.Crashes, opts.Nodes, roundSkips))
+	}
 	specEventCh := make(chan spec.Event, 10000)
 	filters.AddFilter(spec.Log(specEventCh))
 
 	filters.AddFilter(logConsensusMessages)
 	filters.AddFilter(logBlockIds)
 
-	for _, drop := range drops {
+	for _, drop := range opts.Drops {
 		filters.AddFilter(
 			testlib.If(
 				testlib.IsMessageSend().
@@ -120,23 +225,66 @@ func ByzzFuzzInst(
 		)
 	}
 
-	for _, corruption := range corruptions {
+	for _, delay := range opts.Delays {
+		filters.AddFilter(
+			testlib.If(
+				testlib.IsMessageSend().
+					And(isMessageFromTotalRound(delay.Round())).
+					And(common.IsMessageType(delay.MessageType())).
+					And(FromToIsolated(delay.Partition)),
+			).Then(delay.Action(opts.Wake)),
+		)
+	}
+
+	for i, reorder := range opts.Reorders {
 		filters.AddFilter(
 			testlib.If(testlib.IsMessageSend().
-				And(isMessageOfTotalRound(corruption.Round())).
-				And(common.IsMessageType(corruption.MessageType())).
-				And(common.IsMessageFromPart(nodeLabel(corruption.From))).
-				And(IsMessageToOneOf(corruption.To)),
-			).Then(corruption.Action()),
+				And(isMessageInSteps(reorder.Step, reorder.Step+reorder.Steps)).
+				And(common.IsMessageFromPart(nodeLabel(reorder.From))).
+				And(common.IsMessageToPart(nodeLabel(reorder.To))),
+			).Then(reorderAction(i)),
 		)
 	}
 
-	testcase := testlib.NewTestCase("ByzzFuzzInst", timeout+livenessTimeout, sm, filters)
-	testcase.SetupFunc(common.Setup(sp, labelNodes, liveness.SetupLivenessTimer(timeout)))
+	for _, corruption := range opts.Corruptions {
+		cond := testlib.IsMessageSend().
+			And(isMessageOfTotalRound(corruption.Round())).
+			And(common.IsMessageType(corruption.MessageType())).
+			And(common.IsMessageFromPart(nodeLabel(corruption.From)))
+		if !corruption.IsEquivocation() {
+			cond = cond.And(IsMessageToOneOf(corruption.To))
+		}
+		filters.AddFilter(testlib.If(cond).Then(corruption.Action()))
+	}
+
+	// Last, so that held messages are only released alongside events that no fault has handled
+	filters.AddFilter(releaseHeldMessages(opts.Reorders))
+
+	if opts.Record != nil || opts.Replay != nil {
+		filters = scheduleFilters(filters, opts)
+	}
+
+	testcase := testlib.NewTestCase("ByzzFuzzInst", opts.Timeout+opts.LivenessTimeout, sm, filters)
+	testcase.SetupFunc(common.Setup(opts.SysParams, labelNodes, liveness.SetupLivenessTimer(opts.Timeout)))
 
 	return testcase, specEventCh
 }
 
+// oracles is the state machine of ByzzFuzzInst, which ends in a success state once the run is live,
+// or in the state of the first violation
+func oracles(faulty []int, roundSkips testlib.Condition) *testlib.StateMachine {
+	sm := testlib.NewStateMachine()
+	init := sm.Builder()
+	// Before DiffCommits, which also matches the second commit of a replica
+	init.On(spec.DoubleCommit, DoubleCommitLabel)
+	init.On(spec.DiffCommits, DiffCommitsLabel)
+	init.On(spec.InvalidCommit(nodeLabels(faulty)), InvalidCommitLabel)
+	init.On(roundSkips, RoundSkipViolationLabel)
+	init.On(common.HeightReached(maxHeight), testlib.SuccessStateLabel)
+	init.On(common.IsCommit().And(liveness.IsTestFinished), testlib.SuccessStateLabel)
+	return sm
+}
+
 func endTest(e *types.Event, c *testlib.Context) []*types.Message {
 	c.EndTestCase()
 	return []*types.Message{}
@@ -146,6 +294,14 @@ func nodeLabel(idx int) string {
 	return fmt.Sprintf("node%d", idx)
 }
 
+func nodeLabels(idxs []int) []string {
+	labels := make([]string, len(idxs))
+	for i, idx := range idxs {
+		labels[i] = nodeLabel(idx)
+	}
+	return labels
+}
+
 func labelNodes(c *testlib.Context) {
 	parts := make([]*util.Part, len(c.Replicas.Iter()))
 	for i, replica := range c.Replicas.Iter() {
@@ -160,7 +316,7 @@ func labelNodes(c *testlib.Context) {
 	c.Vars.Set("partition", partition)
 	c.Logger().With(log.LogParams{
 		"partition": partition.String(),
-	}).Info("Partitioned replicas")
+	}).Info(partitionLog)
 }
 
 func IsMessageToOneOf(replicaIdxs []int) testlib.Condition {
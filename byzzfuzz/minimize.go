@@ -0,0 +1,259 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Minimize shrinks a failing instance using delta debugging.
+// It removes drops, corruptions, delays, reorders, crashes and timeout settings, merges the blocks of drop and delay partitions and
+// shrinks the recipients of corruptions, for as long as the instance keeps failing.
+// The fails function decides whether a candidate still fails, and should account for flakiness itself.
+// Every distinct candidate is tested at most once.
+func Minimize(inst ByzzFuzzInstanceConfig, fails func(ByzzFuzzInstanceConfig) bool) ByzzFuzzInstanceConfig {
+	m := minimizer{
+		fails:  fails,
+		tested: make(map[string]bool),
+	}
+	current := inst.clone()
+	m.tested[current.Json()] = true
+
+	for {
+		before := current.Json()
+		current = m.minimizeDrops(current)
+		current = m.minimizeCorruptions(current)
+		current = m.minimizeDelays(current)
+		current = m.minimizeReorders(current)
+		current = m.minimizeCrashes(current)
+		current = m.minimizeTimeouts(current)
+		current = m.minimizePartitions(current)
+		current = m.minimizeRecipients(current)
+		if current.Json() == before {
+			break
+		}
+	}
+	return m.minimizeFaulty(current)
+}
+
+type minimizer struct {
+	fails  func(ByzzFuzzInstanceConfig) bool
+	tested map[string]bool
+}
+
+func (m *minimizer) test(candidate ByzzFuzzInstanceConfig) bool {
+	key := candidate.Json()
+	if result, ok := m.tested[key]; ok {
+		return result
+	}
+	log.Printf("Testing candidate: %s", key)
+	result := m.fails(candidate)
+	m.tested[key] = result
+	return result
+}
+
+// minimizeFaults removes the faults of one kind, those in the slice that get returns and set replaces
+func minimizeFaults[T any](m *minimizer, inst ByzzFuzzInstanceConfig, get func(ByzzFuzzInstanceConfig) []T, set func(*ByzzFuzzInstanceConfig, []T)) ByzzFuzzInstanceConfig {
+	faults := get(inst)
+	withFaults := func(keep []int) ByzzFuzzInstanceConfig {
+		candidate := inst.clone()
+		kept := make([]T, len(keep))
+		for i, idx := range keep {
+			kept[i] = faults[idx]
+		}
+		set(&candidate, kept)
+		return candidate
+	}
+	keep := ddmin(len(faults), true, func(keep []int) bool {
+		return m.test(withFaults(keep))
+	})
+	return withFaults(keep)
+}
+
+func (m *minimizer) minimizeDrops(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []MessageDrop { return c.Drops },
+		func(c *ByzzFuzzInstanceConfig, drops []MessageDrop) { c.Drops = drops })
+}
+
+func (m *minimizer) minimizeCorruptions(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []MessageCorruption { return c.Corruptions },
+		func(c *ByzzFuzzInstanceConfig, corruptions []MessageCorruption) { c.Corruptions = corruptions })
+}
+
+func (m *minimizer) minimizeDelays(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []MessageDelay { return c.Delays },
+		func(c *ByzzFuzzInstanceConfig, delays []MessageDelay) { c.Delays = delays })
+}
+
+func (m *minimizer) minimizeReorders(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []MessageReorder { return c.Reorders },
+		func(c *ByzzFuzzInstanceConfig, reorders []MessageReorder) { c.Reorders = reorders })
+}
+
+func (m *minimizer) minimizeCrashes(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []NodeCrash { return c.Crashes },
+		func(c *ByzzFuzzInstanceConfig, crashes []NodeCrash) { c.Crashes = crashes })
+}
+
+func (m *minimizer) minimizeTimeouts(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	return minimizeFaults(m, inst,
+		func(c ByzzFuzzInstanceConfig) []ConsensusTimeouts { return c.Timeouts },
+		func(c *ByzzFuzzInstanceConfig, timeouts []ConsensusTimeouts) { c.Timeouts = timeouts })
+}
+
+// minimizePartitions merges blocks of drop and delay partitions, so that fewer links are cut.
+func (m *minimizer) minimizePartitions(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	for d := range inst.Drops {
+		inst = m.mergePartition(inst, func(c *ByzzFuzzInstanceConfig) *Partition { return &c.Drops[d].Partition })
+	}
+	for d := range inst.Delays {
+		inst = m.mergePartition(inst, func(c *ByzzFuzzInstanceConfig) *Partition { return &c.Delays[d].Partition })
+	}
+	return inst
+}
+
+// mergePartition merges pairs of blocks of the partition that partitionOf points to, for as long as the instance
+// keeps failing
+func (m *minimizer) mergePartition(inst ByzzFuzzInstanceConfig, partitionOf func(*ByzzFuzzInstanceConfig) *Partition) ByzzFuzzInstanceConfig {
+Merge:
+	for {
+		partition := *partitionOf(&inst)
+		// A partition with a single block does not drop or delay anything, that is left to minimizeDrops and
+		// minimizeDelays
+		if len(partition) <= 2 {
+			return inst
+		}
+		for i := 0; i < len(partition); i++ {
+			for j := i + 1; j < len(partition); j++ {
+				candidate := inst.clone()
+				*partitionOf(&candidate) = mergeBlocks(partition, i, j)
+				if m.test(candidate) {
+					inst = candidate
+					continue Merge
+				}
+			}
+		}
+		return inst
+	}
+}
+
+// minimizeRecipients shrinks the set of nodes that receive a corrupted message.
+func (m *minimizer) minimizeRecipients(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	for c := range inst.Corruptions {
+		to := inst.Corruptions[c].To
+		withRecipients := func(keep []int) ByzzFuzzInstanceConfig {
+			candidate := inst.clone()
+			candidate.Corruptions[c].To = make([]int, len(keep))
+			for i, idx := range keep {
+				candidate.Corruptions[c].To[i] = to[idx]
+			}
+			return candidate
+		}
+		// A corruption without recipients is equivalent to removing it, that is left to minimizeCorruptions
+		keep := ddmin(len(to), false, func(keep []int) bool {
+			return m.test(withRecipients(keep))
+		})
+		inst = withRecipients(keep)
+	}
+	return inst
+}
+
+// minimizeFaulty removes faulty nodes that no longer corrupt any messages.
+// This does not change the behaviour of the instance, so it is not tested again.
+func (m *minimizer) minimizeFaulty(inst ByzzFuzzInstanceConfig) ByzzFuzzInstanceConfig {
+	inst.Faulty = inst.corruptingNodes()
+	return inst
+}
+
+func mergeBlocks(p Partition, i int, j int) Partition {
+	merged := make(Partition, 0, len(p)-1)
+	for k, block := range p {
+		switch k {
+		case i:
+			block = append(append([]int{}, p[i]...), p[j]...)
+			sort.Ints(block)
+		case j:
+			continue
+		}
+		merged = append(merged, block)
+	}
+	return merged
+}
+
+// ddmin finds a 1-minimal subset of the indices 0..n-1 for which fails holds,
+// assuming it holds for all of them (Zeller and Hildebrandt, 2002).
+// Every subset is tested at most once, the search comes across some of them again.
+func ddmin(n int, allowEmpty bool, test func(keep []int) bool) []int {
+	tested := make(map[string]bool)
+	fails := func(keep []int) bool {
+		key := fmt.Sprint(keep)
+		if result, ok := tested[key]; ok {
+			return result
+		}
+		tested[key] = test(keep)
+		return tested[key]
+	}
+	current := make([]int, n)
+	for i := range current {
+		current[i] = i
+	}
+	if allowEmpty && n > 0 && fails([]int{}) {
+		return []int{}
+	}
+
+	granularity := 2
+	for len(current) >= 2 {
+		chunks := splitChunks(current, granularity)
+		reduced := false
+		for _, chunk := range chunks {
+			if fails(chunk) {
+				current = chunk
+				granularity = 2
+				reduced = true
+				break
+			}
+		}
+		if !reduced && granularity > 2 {
+			for i := range chunks {
+				complement := make([]int, 0, len(current))
+				for j, chunk := range chunks {
+					if i != j {
+						complement = append(complement, chunk...)
+					}
+				}
+				if fails(complement) {
+					current = complement
+					granularity--
+					reduced = true
+					break
+				}
+			}
+		}
+		if !reduced {
+			if granularity >= len(current) {
+				break
+			}
+			granularity *= 2
+			if granularity > len(current) {
+				granularity = len(current)
+			}
+		}
+	}
+	return current
+}
+
+func splitChunks(items []int, n int) [][]int {
+	chunks := make([][]int, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		end := start + (len(items)-start)/(n-i)
+		chunks = append(chunks, items[start:end])
+		start = end
+	}
+	return chunks
+}
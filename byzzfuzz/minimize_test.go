@@ -0,0 +1,144 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/netrixframework/tendermint-testing/common"
+)
+
+// containsAll fails for subsets that contain all of the required indices
+func containsAll(required ...int) func(keep []int) bool {
+	return func(keep []int) bool {
+		for _, r := range required {
+			if !partContains(keep, r) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// containsAny fails for subsets that contain at least k of the indices
+func containsAtLeast(k int, indices ...int) func(keep []int) bool {
+	return func(keep []int) bool {
+		found := 0
+		for _, i := range indices {
+			if partContains(keep, i) {
+				found++
+			}
+		}
+		return found >= k
+	}
+}
+
+// testDdmin runs ddmin and checks that every candidate was tested once, and that the result fails and is 1-minimal
+func testDdmin(t *testing.T, n int, allowEmpty bool, fails func(keep []int) bool) []int {
+	t.Helper()
+	tested := make(map[string]int)
+	result := ddmin(n, allowEmpty, func(keep []int) bool {
+		tested[fmt.Sprint(keep)]++
+		return fails(keep)
+	})
+	for candidate, times := range tested {
+		if times > 1 {
+			t.Errorf("n=%d: tested %s %d times", n, candidate, times)
+		}
+	}
+	if !fails(result) {
+		t.Errorf("n=%d: result %v does not fail", n, result)
+	}
+	for i := range result {
+		smaller := append(append([]int{}, result[:i]...), result[i+1:]...)
+		if (len(smaller) > 0 || allowEmpty) && fails(smaller) {
+			t.Errorf("n=%d: result %v is not 1-minimal, %v fails as well", n, result, smaller)
+		}
+	}
+	return result
+}
+
+func TestDdminFindsRequiredIndices(t *testing.T) {
+	for _, test := range []struct {
+		n        int
+		required []int
+	}{
+		{1, []int{0}},
+		{2, []int{1}},
+		{8, []int{3, 7}},
+		{9, []int{0, 4, 8}},
+		{16, []int{5}},
+	} {
+		result := testDdmin(t, test.n, false, containsAll(test.required...))
+		if !reflect.DeepEqual(result, test.required) {
+			t.Errorf("n=%d: got %v, want %v", test.n, result, test.required)
+		}
+	}
+}
+
+func TestDdminIsOneMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for run := 0; run < 200; run++ {
+		n := 1 + r.Intn(20)
+		indices := r.Perm(n)[:1+r.Intn(n)]
+		k := 1 + r.Intn(len(indices))
+		testDdmin(t, n, false, containsAtLeast(k, indices...))
+	}
+}
+
+func TestDdminAllowsEmptyResult(t *testing.T) {
+	always := func(keep []int) bool { return true }
+	if result := testDdmin(t, 5, true, always); len(result) != 0 {
+		t.Errorf("got %v, want no indices", result)
+	}
+	if result := testDdmin(t, 5, false, always); len(result) != 1 {
+		t.Errorf("got %v, want a single index", result)
+	}
+}
+
+func TestMinimizeMergesDelayPartitions(t *testing.T) {
+	inst := ByzzFuzzInstanceConfig{
+		sysParams: common.NewSystemParams(4),
+		Delays:    []MessageDelay{{Step: 1, Partition: Partition{{0}, {1}, {2}, {3}}, Steps: 1}},
+	}
+	// Fails for as long as node0 is cut off from node3
+	fails := func(candidate ByzzFuzzInstanceConfig) bool {
+		if len(candidate.Delays) == 0 {
+			return false
+		}
+		for _, block := range candidate.Delays[0].Partition {
+			if partContains(block, 0) && partContains(block, 3) {
+				return false
+			}
+		}
+		return true
+	}
+	result := Minimize(inst, fails)
+	if len(result.Delays) != 1 || len(result.Delays[0].Partition) != 2 {
+		t.Fatalf("got delays %v, want a single delay with a partition into two blocks", result.Delays)
+	}
+	if err := validatePartition(4, result.Delays[0].Partition); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidatePartition(t *testing.T) {
+	for _, test := range []struct {
+		p     Partition
+		valid bool
+	}{
+		{Partition{{0, 1}, {2, 3}}, true},
+		{Partition{{0}, {1}, {2}, {3}}, true},
+		{Partition{{0, 1, 2, 3}}, true},
+		{Partition{{0, 1}, {2}}, false},
+		{Partition{{0, 1}, {2, 3, 4}}, false},
+		{Partition{{0, 1}, {1, 2, 3}}, false},
+		{Partition{{0, 1, 2, 3}, {}}, false},
+		{Partition{{-1}, {0, 1, 2, 3}}, false},
+	} {
+		if err := validatePartition(4, test.p); (err == nil) != test.valid {
+			t.Errorf("validatePartition(%v) = %v, want valid %v", test.p, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package byzzfuzz
+
+import (
+	"math/rand"
+)
+
+// Maximum distance in steps that a mutation shifts a drop by
+const maxShift = 2
+
+// Attempts at finding a mutation that applies to an instance and keeps it valid
+const maxMutationAttempts = 100
+
+type mutation func(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool
+
+var mutations = []mutation{
+	addDrop,
+	removeDrop,
+	shiftDrop,
+	changePartition,
+	changeCorruptionType,
+}
+
+// Mutate returns a variant of the instance for coverage-guided fuzzing: it adds, removes or shifts a drop,
+// changes the partition of a drop or changes the type of a corruption. The variant is valid, if no mutation
+// applies within a bounded number of attempts, an unchanged copy is returned.
+// Mutated instances can no longer be generated from their seed, which is therefore cleared.
+func Mutate(inst ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) ByzzFuzzInstanceConfig {
+	for i := 0; i < maxMutationAttempts; i++ {
+		candidate := inst.clone()
+		if !mutations[r.Intn(len(mutations))](&candidate, r, scope, steps) {
+			continue
+		}
+		if candidate.Validate() != nil {
+			continue
+		}
+		candidate.Seed = 0
+		return candidate
+	}
+	return inst.clone()
+}
+
+func addDrop(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool {
+	drop := MessageDrop{
+		Step:      r.Intn(steps),
+		Partition: RandomPartition(inst.sysParams, r),
+	}
+	if scope == GossipScope {
+		drop.GossipType = randomGossipType(r)
+	}
+	inst.Drops = append(inst.Drops, drop)
+	return true
+}
+
+func removeDrop(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool {
+	if len(inst.Drops) == 0 {
+		return false
+	}
+	i := r.Intn(len(inst.Drops))
+	inst.Drops = append(inst.Drops[:i], inst.Drops[i+1:]...)
+	return true
+}
+
+func shiftDrop(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool {
+	if len(inst.Drops) == 0 {
+		return false
+	}
+	drop := &inst.Drops[r.Intn(len(inst.Drops))]
+	shift := 1 + r.Intn(maxShift)
+	if r.Intn(2) == 0 {
+		shift = -shift
+	}
+	if drop.Step+shift < 0 || drop.Step+shift >= steps {
+		return false
+	}
+	drop.Step += shift
+	return true
+}
+
+func changePartition(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool {
+	if len(inst.Drops) == 0 {
+		return false
+	}
+	drop := &inst.Drops[r.Intn(len(inst.Drops))]
+	previous := drop.Partition
+	drop.Partition = RandomPartition(inst.sysParams, r)
+	return !samePartition(inst.sysParams.N, drop.Partition, previous)
+}
+
+// samePartition tells whether two partitions isolate the same pairs of nodes
+func samePartition(n int, a Partition, b Partition) bool {
+	for from := 0; from < n; from++ {
+		for to := 0; to < n; to++ {
+			if isolates(a, from, to) != isolates(b, from, to) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func changeCorruptionType(inst *ByzzFuzzInstanceConfig, r *rand.Rand, scope Scope, steps int) bool {
+	if len(inst.Corruptions) == 0 {
+		return false
+	}
+	corruption := &inst.Corruptions[r.Intn(len(inst.Corruptions))]
+	previous := corruption.Corruption
+	if corruption.GossipType != "" {
+		corruption.Corruption = randomGossipCorruption(r, corruption.GossipType)
+	} else {
+		corruption.Corruption = randomCorruption(r, scope, corruption.Step)
+	}
+	return corruption.Corruption != previous
+}
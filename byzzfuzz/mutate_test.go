@@ -0,0 +1,169 @@
+package byzzfuzz
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netrixframework/tendermint-testing/common"
+)
+
+const testSteps = 10
+
+func mutationTestInstance() ByzzFuzzInstanceConfig {
+	return ByzzFuzzInstanceConfig{
+		sysParams: common.NewSystemParams(4),
+		Drops: []MessageDrop{
+			{Step: 0, Partition: Partition{{0}, {1, 2, 3}}},
+			{Step: 5, Partition: Partition{{0, 1}, {2, 3}}},
+		},
+		Corruptions:     []MessageCorruption{{Step: 1, From: 0, To: []int{1, 2}, Corruption: ChangeVoteToNil}},
+		Faulty:          []int{0},
+		Seed:            42,
+		Timeout:         time.Minute,
+		LivenessTimeout: time.Minute,
+	}
+}
+
+// changedIndices returns the indices at which two lists of equal length differ
+func changedIndices(n int, differs func(i int) bool) []int {
+	changed := make([]int, 0)
+	for i := 0; i < n; i++ {
+		if differs(i) {
+			changed = append(changed, i)
+		}
+	}
+	return changed
+}
+
+func TestMutations(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mutation mutation
+		// claim checks that the mutation changed the instance the way it claims to
+		claim func(t *testing.T, before ByzzFuzzInstanceConfig, after ByzzFuzzInstanceConfig)
+	}{
+		{"addDrop", addDrop, func(t *testing.T, before, after ByzzFuzzInstanceConfig) {
+			if len(after.Drops) != len(before.Drops)+1 || !reflect.DeepEqual(after.Drops[:len(before.Drops)], before.Drops) {
+				t.Errorf("drops %v do not extend %v by one drop", after.Drops, before.Drops)
+			}
+			if added := after.Drops[len(after.Drops)-1]; added.Step < 0 || added.Step >= testSteps {
+				t.Errorf("added drop at step %d, outside of %d steps", added.Step, testSteps)
+			}
+		}},
+		{"removeDrop", removeDrop, func(t *testing.T, before, after ByzzFuzzInstanceConfig) {
+			if len(after.Drops) != len(before.Drops)-1 {
+				t.Fatalf("%d drops left of %d", len(after.Drops), len(before.Drops))
+			}
+			for i := range before.Drops {
+				rest := append(append([]MessageDrop{}, before.Drops[:i]...), before.Drops[i+1:]...)
+				if reflect.DeepEqual(after.Drops, rest) {
+					return
+				}
+			}
+			t.Errorf("drops %v are not %v without one drop", after.Drops, before.Drops)
+		}},
+		{"shiftDrop", shiftDrop, func(t *testing.T, before, after ByzzFuzzInstanceConfig) {
+			changed := changedIndices(len(before.Drops), func(i int) bool { return !reflect.DeepEqual(before.Drops[i], after.Drops[i]) })
+			if len(changed) != 1 {
+				t.Fatalf("changed drops %v, want a single drop", changed)
+			}
+			b, a := before.Drops[changed[0]], after.Drops[changed[0]]
+			shift := a.Step - b.Step
+			a.Step = b.Step
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("shifted drop %v changed more than the step of %v", a, b)
+			}
+			if shift == 0 || shift > maxShift || shift < -maxShift {
+				t.Errorf("shifted drop by %d steps, want at most %d", shift, maxShift)
+			}
+		}},
+		{"changePartition", changePartition, func(t *testing.T, before, after ByzzFuzzInstanceConfig) {
+			changed := changedIndices(len(before.Drops), func(i int) bool { return !reflect.DeepEqual(before.Drops[i], after.Drops[i]) })
+			if len(changed) != 1 {
+				t.Fatalf("changed drops %v, want a single drop", changed)
+			}
+			b, a := before.Drops[changed[0]], after.Drops[changed[0]]
+			if samePartition(4, a.Partition, b.Partition) {
+				t.Errorf("partition %v isolates the same nodes as %v", a.Partition, b.Partition)
+			}
+			a.Partition = b.Partition
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("drop %v changed more than the partition of %v", a, b)
+			}
+		}},
+		{"changeCorruptionType", changeCorruptionType, func(t *testing.T, before, after ByzzFuzzInstanceConfig) {
+			changed := changedIndices(len(before.Corruptions), func(i int) bool {
+				return !reflect.DeepEqual(before.Corruptions[i], after.Corruptions[i])
+			})
+			if len(changed) != 1 {
+				t.Fatalf("changed corruptions %v, want a single corruption", changed)
+			}
+			b, a := before.Corruptions[changed[0]], after.Corruptions[changed[0]]
+			if a.Corruption == b.Corruption {
+				t.Errorf("corruption type %d did not change", a.Corruption)
+			}
+			a.Corruption = b.Corruption
+			if !reflect.DeepEqual(a, b) {
+				t.Errorf("corruption %v changed more than the type of %v", a, b)
+			}
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			applied := 0
+			for i := 0; i < 100; i++ {
+				before := mutationTestInstance()
+				after := before.clone()
+				if !test.mutation(&after, r, SmallScope, testSteps) {
+					continue
+				}
+				applied++
+				if err := after.Validate(); err != nil {
+					t.Fatalf("mutated instance is invalid: %s", err)
+				}
+				if !reflect.DeepEqual(before, mutationTestInstance()) {
+					t.Fatalf("mutation changed the original instance")
+				}
+				test.claim(t, before, after)
+			}
+			if applied == 0 {
+				t.Error("mutation never applied")
+			}
+		})
+	}
+}
+
+func TestMutationsWithoutDropsOrCorruptions(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for name, m := range map[string]mutation{
+		"removeDrop":           removeDrop,
+		"shiftDrop":            shiftDrop,
+		"changePartition":      changePartition,
+		"changeCorruptionType": changeCorruptionType,
+	} {
+		inst := ByzzFuzzInstanceConfig{sysParams: common.NewSystemParams(4)}
+		if m(&inst, r, SmallScope, testSteps) {
+			t.Errorf("%s applied to an instance without drops and corruptions", name)
+		}
+	}
+}
+
+func TestMutate(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		inst := mutationTestInstance()
+		mutated := Mutate(inst, r, SmallScope, testSteps)
+		if err := mutated.Validate(); err != nil {
+			t.Fatalf("mutated instance is invalid: %s", err)
+		}
+		if mutated.Seed != 0 {
+			t.Errorf("mutated instance keeps seed %d", mutated.Seed)
+		}
+		mutated.Seed = inst.Seed
+		if reflect.DeepEqual(mutated, inst) {
+			t.Errorf("instance did not change")
+		}
+	}
+}
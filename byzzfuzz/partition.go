@@ -1,42 +1,104 @@
 package byzzfuzz
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
+	"sync"
 
 	"github.com/netrixframework/netrix/testlib"
 	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/common"
 )
 
 type Partition = [][]int
 
-var allPartitions []Partition = []Partition{
-	// 1 partition (disabled because it is equivalent to having no partition at all)
-	// {{0, 1, 2, 3}},
-
-	// 2 partitions
-	{{0, 1}, {2, 3}},
-	{{0, 2}, {1, 3}},
-	{{0, 3}, {1, 2}},
-	{{0}, {1, 2, 3}},
-	{{1}, {0, 2, 3}},
-	{{2}, {0, 1, 3}},
-	{{3}, {0, 1, 2}},
-
-	// 3 partitions
-	{{0}, {1}, {2, 3}},
-	{{0}, {2}, {1, 3}},
-	{{0}, {3}, {1, 2}},
-	{{1}, {2}, {0, 3}},
-	{{1}, {3}, {0, 2}},
-	{{2}, {3}, {0, 1}},
-
-	// 4 partitions
-	{{0}, {1}, {2}, {3}},
+// Partitions are generated once per cluster size, and then reused
+var partitionCache = struct {
+	sync.Mutex
+	byN map[int][]Partition
+}{byN: make(map[int][]Partition)}
+
+// AllPartitions returns every partition of the replicas into two or more blocks.
+// The partition with a single block is excluded because it is equivalent to having no partition at all.
+func AllPartitions(sp *common.SystemParams) []Partition {
+	partitionCache.Lock()
+	defer partitionCache.Unlock()
+
+	partitions, ok := partitionCache.byN[sp.N]
+	if !ok {
+		partitions = generatePartitions(sp.N)
+		partitionCache.byN[sp.N] = partitions
+	}
+	return partitions
+}
+
+// generatePartitions enumerates the set partitions of {0, ..., n-1} using restricted growth strings,
+// ordered by the number of blocks.
+func generatePartitions(n int) []Partition {
+	byBlocks := make([][]Partition, n+1)
+	assignment := make([]int, n)
+
+	var assign func(i int, blocks int)
+	assign = func(i int, blocks int) {
+		if i == n {
+			p := make(Partition, blocks)
+			for node, block := range assignment {
+				p[block] = append(p[block], node)
+			}
+			byBlocks[blocks] = append(byBlocks[blocks], p)
+			return
+		}
+		// Node i joins an existing block, or starts a new one
+		for block := 0; block <= blocks; block++ {
+			assignment[i] = block
+			if block == blocks {
+				assign(i+1, blocks+1)
+			} else {
+				assign(i+1, blocks)
+			}
+		}
+	}
+	if n > 0 {
+		assignment[0] = 0
+		assign(1, 1)
+	}
+
+	partitions := make([]Partition, 0)
+	for blocks := 2; blocks <= n; blocks++ {
+		partitions = append(partitions, byBlocks[blocks]...)
+	}
+	return partitions
+}
+
+// validatePartition checks that a partition splits the nodes 0..n-1 into non-empty blocks
+func validatePartition(n int, p Partition) error {
+	seen := make([]bool, n)
+	for _, block := range p {
+		if len(block) == 0 {
+			return fmt.Errorf("partition %v has an empty block", p)
+		}
+		for _, node := range block {
+			if node < 0 || node >= n {
+				return fmt.Errorf("partition %v contains node %d, which does not exist in a cluster of %d nodes", p, node, n)
+			}
+			if seen[node] {
+				return fmt.Errorf("partition %v contains node %d more than once", p, node)
+			}
+			seen[node] = true
+		}
+	}
+	for node, ok := range seen {
+		if !ok {
+			return fmt.Errorf("partition %v leaves out node %d", p, node)
+		}
+	}
+	return nil
 }
 
-func RandomPartition(r *rand.Rand) Partition {
-	return allPartitions[r.Intn(len(allPartitions))]
+func RandomPartition(sp *common.SystemParams, r *rand.Rand) Partition {
+	partitions := AllPartitions(sp)
+	return partitions[r.Intn(len(partitions))]
 }
 
 func FromToIsolated(p Partition) testlib.Condition {
@@ -0,0 +1,117 @@
+package byzzfuzz
+
+import (
+	"fmt"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// peerView is the height/round a replica believes a peer is at, learnt from the gossip messages it received
+type peerView struct {
+	height int
+	round  int
+}
+
+func (v peerView) before(other peerView) bool {
+	return v.height < other.height || (v.height == other.height && v.round < other.round)
+}
+
+// trackPeerRounds updates the view of the receiver on the sender, from the NewRoundStep, HasVote and VoteSetMaj23
+// messages that Tendermint gossips. Views only move forward, since gossip messages may arrive out of order.
+func trackPeerRounds(e *types.Event, c *testlib.Context) (messages []*types.Message, handled bool) {
+	if !e.IsMessageReceive() {
+		return
+	}
+	message, ok := util.GetMessageFromEvent(e, c)
+	if !ok {
+		return
+	}
+	var view peerView
+	switch message.Type {
+	case util.NewRoundStep, util.HasVote, util.VoteSetMaj23:
+		view.height, view.round = messageHeightRound(message)
+	default:
+		return
+	}
+	if view.round < 0 {
+		return
+	}
+
+	replica := getPartLabel(c, message.To)
+	peer := getPartLabel(c, message.From)
+	key := peerViewKey(replica, peer)
+	if prev, ok := c.Vars.Get(key); ok && !prev.(peerView).before(view) {
+		return
+	}
+	c.Vars.Set(key, view)
+	c.Logger().With(log.LogParams{
+		"replica": replica,
+		"peer":    peer,
+		"height":  view.height,
+		"round":   view.round,
+		"type":    message.Type,
+	}).Debug("Updated view of peer")
+	return
+}
+
+func peerViewKey(replica string, peer string) string {
+	return fmt.Sprintf("BF_peer_view_%s_%s", replica, peer)
+}
+
+func getPeerView(c *testlib.Context, replica int, peer int) (peerView, bool) {
+	view, ok := c.Vars.Get(peerViewKey(nodeLabel(replica), nodeLabel(peer)))
+	if !ok {
+		return peerView{}, false
+	}
+	return view.(peerView), true
+}
+
+// BelievesPeerAtRound is true if the replica believes that the peer is at the given round, of any height
+func BelievesPeerAtRound(replica int, peer int, round int) testlib.Condition {
+	return func(e *types.Event, c *testlib.Context) bool {
+		view, ok := getPeerView(c, replica, peer)
+		return ok && view.round == round
+	}
+}
+
+// BelievesPeerAtHeightRound is true if the replica believes that the peer is at the given height and round
+func BelievesPeerAtHeightRound(replica int, peer int, height int, round int) testlib.Condition {
+	return func(e *types.Event, c *testlib.Context) bool {
+		view, ok := getPeerView(c, replica, peer)
+		return ok && view == peerView{height: height, round: round}
+	}
+}
+
+// BelievesPeerBehind is true if the replica believes that the peer is at an earlier height/round than the
+// replica itself, as reported by its newStep events
+func BelievesPeerBehind(replica int, peer int) testlib.Condition {
+	return func(e *types.Event, c *testlib.Context) bool {
+		view, ok := getPeerView(c, replica, peer)
+		if !ok {
+			return false
+		}
+		own, ok := ownView(c, nodeLabel(replica))
+		return ok && view.before(own)
+	}
+}
+
+// ownView is the height/round of the replica as tracked by trackTotalRounds
+func ownView(c *testlib.Context, label string) (peerView, bool) {
+	partitionR, ok := c.Vars.Get("partition")
+	if !ok {
+		return peerView{}, false
+	}
+	for _, p := range partitionR.(*util.Partition).Parts {
+		ids := p.ReplicaSet.Iter()
+		if p.Label != label || len(ids) == 0 {
+			continue
+		}
+		height, okH := c.Vars.GetInt(prevHeightKey(ids[0]))
+		round, okR := c.Vars.GetInt(prevRoundKey(ids[0]))
+		return peerView{height: height, round: round}, okH && okR
+	}
+	return peerView{}, false
+}
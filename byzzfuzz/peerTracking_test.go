@@ -0,0 +1,172 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/netrixframework/netrix/config"
+	"github.com/netrixframework/netrix/context"
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+	tmsg "github.com/tendermint/tendermint/proto/tendermint/consensus"
+)
+
+// peerTracker feeds synthetic events through trackTotalRounds and trackPeerRounds, and keeps the context they
+// update for the conditions under test
+type peerTracker struct {
+	root   *context.RootContext
+	driver *testlib.TestCaseDriver
+	ctx    *testlib.Context
+	events int
+}
+
+func newPeerTracker(t *testing.T) *peerTracker {
+	logger := log.NewLogger(config.LogConfig{Format: "json", Path: filepath.Join(t.TempDir(), "checker.log")})
+	root := context.NewRootContext(&config.Config{NumReplicas: 4}, logger)
+	parts := make([]*util.Part, 4)
+	for i := range parts {
+		replica := &types.Replica{ID: types.ReplicaID(nodeLabel(i))}
+		root.Replicas.Add(replica)
+		parts[i] = &util.Part{ReplicaSet: util.NewReplicaSet(), Label: nodeLabel(i)}
+		parts[i].ReplicaSet.Add(replica)
+	}
+	partition := util.NewPartition(parts...)
+
+	p := &peerTracker{root: root}
+	filters := testlib.NewFilterSet()
+	filters.AddFilter(func(e *types.Event, c *testlib.Context) ([]*types.Message, bool) {
+		c.Vars.Set("partition", partition)
+		p.ctx = c
+		return nil, false
+	})
+	filters.AddFilter(trackTotalRounds)
+	filters.AddFilter(trackPeerRounds)
+	testcase := testlib.NewTestCase("PeerTracking", 0, testlib.NewStateMachine(), filters)
+	testcase.Logger = logger
+	p.driver = testlib.NewTestDriver(root, testcase)
+	return p
+}
+
+func (p *peerTracker) step(replica int, eType types.EventType) {
+	p.events++
+	p.driver.Step(types.NewEvent(types.ReplicaID(nodeLabel(replica)), eType, eType.String(), types.EventID(p.events), int64(p.events)))
+}
+
+// newStep is a step of the replica itself
+func (p *peerTracker) newStep(replica int, height int, round int) {
+	p.step(replica, types.NewGenericEventType(map[string]string{
+		"height": strconv.Itoa(height),
+		"round":  strconv.Itoa(round),
+		"step":   "RoundStepPropose",
+	}, "newStep"))
+}
+
+// receive delivers a gossip message from peer to replica
+func (p *peerTracker) receive(replica int, peer int, mType util.MessageType, data *tmsg.Message) {
+	id := fmt.Sprintf("message%d", p.events)
+	from, to := types.ReplicaID(nodeLabel(peer)), types.ReplicaID(nodeLabel(replica))
+	p.root.MessageStore.Add(&types.Message{
+		ID:            id,
+		From:          from,
+		To:            to,
+		Type:          string(mType),
+		ParsedMessage: &util.TMessage{From: from, To: to, Type: mType, Data: data},
+	})
+	p.step(replica, types.NewMessageReceiveEventType(id))
+}
+
+func (p *peerTracker) newRoundStep(replica int, peer int, height int, round int) {
+	p.receive(replica, peer, util.NewRoundStep, &tmsg.Message{Sum: &tmsg.Message_NewRoundStep{
+		NewRoundStep: &tmsg.NewRoundStep{Height: int64(height), Round: int32(round)},
+	}})
+}
+
+func (p *peerTracker) hasVote(replica int, peer int, height int, round int) {
+	p.receive(replica, peer, util.HasVote, &tmsg.Message{Sum: &tmsg.Message_HasVote{
+		HasVote: &tmsg.HasVote{Height: int64(height), Round: int32(round)},
+	}})
+}
+
+func (p *peerTracker) voteSetMaj23(replica int, peer int, height int, round int) {
+	p.receive(replica, peer, util.VoteSetMaj23, &tmsg.Message{Sum: &tmsg.Message_VoteSetMaj23{
+		VoteSetMaj23: &tmsg.VoteSetMaj23{Height: int64(height), Round: int32(round)},
+	}})
+}
+
+func (p *peerTracker) holds(cond testlib.Condition) bool {
+	return p.ctx != nil && cond(nil, p.ctx)
+}
+
+func TestTrackPeerRoundsFromGossip(t *testing.T) {
+	p := newPeerTracker(t)
+	p.newRoundStep(0, 1, 1, 0)
+	if !p.holds(BelievesPeerAtHeightRound(0, 1, 1, 0)) {
+		t.Error("NewRoundStep did not set the view of node0 on node1")
+	}
+	p.hasVote(0, 1, 1, 1)
+	if !p.holds(BelievesPeerAtHeightRound(0, 1, 1, 1)) || !p.holds(BelievesPeerAtRound(0, 1, 1)) {
+		t.Error("HasVote did not move the view of node0 on node1 to round 1")
+	}
+	p.voteSetMaj23(0, 1, 2, 0)
+	if !p.holds(BelievesPeerAtHeightRound(0, 1, 2, 0)) || !p.holds(BelievesPeerAtRound(0, 1, 0)) {
+		t.Error("VoteSetMaj23 did not move the view of node0 on node1 to height 2")
+	}
+	// Views are per receiver and sender
+	if p.holds(BelievesPeerAtHeightRound(1, 0, 2, 0)) || p.holds(BelievesPeerAtHeightRound(2, 1, 2, 0)) {
+		t.Error("the view of node0 on node1 leaked to other pairs")
+	}
+}
+
+func TestTrackPeerRoundsOnlyMovesForward(t *testing.T) {
+	p := newPeerTracker(t)
+	p.newRoundStep(0, 1, 2, 1)
+	// Late gossip of earlier heights and rounds
+	p.hasVote(0, 1, 2, 0)
+	p.newRoundStep(0, 1, 1, 3)
+	if !p.holds(BelievesPeerAtHeightRound(0, 1, 2, 1)) {
+		t.Error("the view of node0 on node1 moved back")
+	}
+	if p.holds(BelievesPeerAtRound(0, 1, 3)) || p.holds(BelievesPeerAtRound(0, 1, 0)) {
+		t.Error("the view of node0 on node1 is at an earlier round")
+	}
+}
+
+func TestTrackPeerRoundsIgnoresOtherMessages(t *testing.T) {
+	p := newPeerTracker(t)
+	p.receive(0, 1, util.Prevote, &tmsg.Message{Sum: &tmsg.Message_Vote{Vote: &tmsg.Vote{}}})
+	if p.holds(BelievesPeerAtRound(0, 1, 0)) {
+		t.Error("a vote set the view of node0 on node1")
+	}
+}
+
+func TestBelievesPeerBehind(t *testing.T) {
+	p := newPeerTracker(t)
+	// Without a view on the peer, or of the replica itself, the replica does not believe anything
+	p.newRoundStep(0, 1, 1, 0)
+	if p.holds(BelievesPeerBehind(0, 1)) {
+		t.Error("node0 believes node1 is behind before it took a step itself")
+	}
+	p.newStep(0, 1, 0)
+	if p.holds(BelievesPeerBehind(0, 1)) {
+		t.Error("node0 believes node1 is behind at the same height and round")
+	}
+	p.newStep(0, 1, 2)
+	if !p.holds(BelievesPeerBehind(0, 1)) {
+		t.Error("node0 does not believe node1 is behind at an earlier round")
+	}
+	p.hasVote(0, 1, 1, 2)
+	if p.holds(BelievesPeerBehind(0, 1)) {
+		t.Error("node0 believes node1 is behind after it caught up")
+	}
+	p.newStep(0, 2, 0)
+	if !p.holds(BelievesPeerBehind(0, 1)) {
+		t.Error("node0 does not believe node1 is behind at an earlier height")
+	}
+	if p.holds(BelievesPeerBehind(0, 2)) {
+		t.Error("node0 believes node2 is behind without a view on it")
+	}
+}
@@ -89,7 +89,7 @@ func isMessageFromTotalRound(round int) testlib.Condition {
 		if !ok {
 			panic("Message not found!")
 		}
-		if message.Round() == -1 {
+		if _, round := messageHeightRound(message); round == -1 {
 			return false
 		}
 
@@ -115,12 +115,12 @@ func isMessageOfTotalRound(round int) testlib.Condition {
 		if !ok {
 			panic("Message not found!")
 		}
-		if message.Round() == -1 {
+		height, round := messageHeightRound(message)
+		if round == -1 {
 			return false
 		}
 
-		totalRounds, ok := c.Vars.GetInt(
-			totalRoundForHeightRoundKey(e.Replica, message.Height(), message.Round()))
+		totalRounds, ok := c.Vars.GetInt(totalRoundForHeightRoundKey(e.Replica, height, round))
 		if !ok {
 			// This can happen if the node is byzantine and produces a message for an invalid round
 			return false
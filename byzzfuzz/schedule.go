@@ -0,0 +1,505 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/liveness"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/common"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// Kinds of schedule entries
+const (
+	// A replica sent a message, the decision says what the filters did with it
+	scheduleSend = "send"
+	// The filters delivered a message, in the order the testing server dispatched them
+	scheduleDeliver = "deliver"
+	// A replica received a message
+	scheduleReceive = "receive"
+	// A replica moved to a new step, or committed a block. These are not replayed.
+	scheduleStep   = "step"
+	scheduleCommit = "commit"
+)
+
+// Decisions of the filters on sent messages
+const (
+	decisionDeliver = "deliver"
+	decisionDrop    = "drop"
+	// Held by a delay or reorder
+	decisionHold = "hold"
+	// Replaced with corrupted messages
+	decisionCorrupt = "corrupt"
+)
+
+// ScheduleEntry is a message event of a run, the replicas are labelled as in the instance config.
+// Steps and commits name their replica in From.
+type ScheduleEntry struct {
+	Kind string `json:"kind"`
+	// Unix time in nanoseconds
+	Time int64 `json:"time,omitempty"`
+	// Corrupted messages are delivered with another ID than they were sent with
+	ID       string           `json:"id,omitempty"`
+	From     string           `json:"from"`
+	To       string           `json:"to,omitempty"`
+	Type     util.MessageType `json:"type,omitempty"`
+	Height   int              `json:"height"`
+	Round    int              `json:"round"`
+	Step     string           `json:"step,omitempty"`
+	Decision string           `json:"decision,omitempty"`
+	// Block hash of proposals, votes and commits, empty for nil votes
+	Value string `json:"value,omitempty"`
+	// POL round of proposals
+	ValidRound int `json:"valid_round,omitempty"`
+}
+
+// Schedule is the order of the message events of a run
+type Schedule struct {
+	Entries []ScheduleEntry
+
+	mtx sync.Mutex
+}
+
+func NewSchedule() *Schedule {
+	return &Schedule{Entries: make([]ScheduleEntry, 0)}
+}
+
+// ReadSchedule reads a schedule written by Write
+func ReadSchedule(r io.Reader) (*Schedule, error) {
+	s := NewSchedule()
+	decoder := json.NewDecoder(r)
+	for {
+		var entry ScheduleEntry
+		err := decoder.Decode(&entry)
+		if err == io.EOF {
+			return s, nil
+		} else if err != nil {
+			return nil, err
+		}
+		s.Entries = append(s.Entries, entry)
+	}
+}
+
+// Write writes the entries as JSON lines
+func (s *Schedule) Write(w io.Writer) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	encoder := json.NewEncoder(w)
+	for _, entry := range s.Entries {
+		err := encoder.Encode(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schedule) add(entry ScheduleEntry) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Entries = append(s.Entries, entry)
+}
+
+func scheduleEntry(c *testlib.Context, kind string, id string, m *util.TMessage) ScheduleEntry {
+	entry := ScheduleEntry{
+		Kind:   kind,
+		Time:   time.Now().UnixNano(),
+		ID:     id,
+		From:   getPartLabel(c, m.From),
+		To:     getPartLabel(c, m.To),
+		Type:   m.Type,
+		Height: m.Height(),
+		Round:  m.Round(),
+	}
+	switch m.Type {
+	case util.Proposal:
+		entry.Value, _ = util.GetProposalBlockIDS(m)
+		entry.ValidRound = int(m.Data.GetProposal().Proposal.PolRound)
+	case util.Prevote, util.Precommit:
+		entry.Value, _ = util.GetVoteBlockIDS(m)
+	}
+	return entry
+}
+
+// parseMessage parses messages that the filters created, such as corrupted messages, which are not parsed yet
+func parseMessage(message *types.Message) (*util.TMessage, bool) {
+	if m, ok := util.GetParsedMessage(message); ok {
+		return m, true
+	}
+	if message == nil || len(message.Data) == 0 {
+		return nil, false
+	}
+	parsed, err := (&util.TMessageParser{}).Parse(message.Data)
+	if err != nil {
+		return nil, false
+	}
+	m, ok := parsed.(*util.TMessage)
+	return m, ok && m.Type != util.None
+}
+
+// scheduleFilters runs the filters like a FilterSet, and records the message events of the run and the decisions
+// of the filters in opts.Record. With opts.Replay, the run follows the replayed schedule, see sequencer.
+func scheduleFilters(filters *testlib.FilterSet, opts InstanceOptions) *testlib.FilterSet {
+	record := opts.Record
+	if record == nil {
+		record = NewSchedule()
+	}
+	var seq *sequencer
+	if opts.Replay != nil {
+		seq = newSequencer(opts.Replay, opts.Corruptions, opts.Wake)
+	}
+	scheduled := testlib.NewFilterSet()
+	scheduled.AddFilter(func(e *types.Event, c *testlib.Context) ([]*types.Message, bool) {
+		messages, handled := []*types.Message{}, false
+		for _, filter := range filters.Filters {
+			messages, handled = filter(e, c)
+			if handled {
+				break
+			}
+		}
+		if !handled {
+			messages, _ = filters.DefaultFilter(e, c)
+		}
+
+		if m, ok := util.GetMessageFromEvent(e, c); ok {
+			id, _ := e.MessageID()
+			if e.IsMessageSend() {
+				entry := scheduleEntry(c, scheduleSend, id, m)
+				entry.Decision = sendDecision(e, c, m, messages)
+				if seq != nil {
+					messages = seq.replayDecision(e, c, entry, messages)
+					entry.Decision = sendDecision(e, c, m, messages)
+				}
+				record.add(entry)
+			} else if e.IsMessageReceive() {
+				record.add(scheduleEntry(c, scheduleReceive, id, m))
+			}
+		} else if entry, ok := stepEntry(e, c); ok {
+			record.add(entry)
+		}
+		if seq != nil {
+			messages = seq.sequence(e, c, messages)
+		}
+		for _, message := range messages {
+			if m, ok := parseMessage(message); ok {
+				record.add(scheduleEntry(c, scheduleDeliver, message.ID, m))
+			}
+		}
+		return messages, true
+	})
+	return scheduled
+}
+
+// stepEntry records new steps and commits of replicas
+func stepEntry(e *types.Event, c *testlib.Context) (ScheduleEntry, bool) {
+	eType, ok := e.Type.(*types.GenericEventType)
+	if !ok || (eType.T != "newStep" && eType.T != "Committing block") {
+		return ScheduleEntry{}, false
+	}
+	entry := ScheduleEntry{
+		Kind: scheduleStep,
+		Time: time.Now().UnixNano(),
+		From: getPartLabel(c, e.Replica),
+		Step: strings.TrimPrefix(eType.Params["step"], "RoundStep"),
+	}
+	if eType.T == "Committing block" {
+		entry.Kind = scheduleCommit
+		entry.Value = eType.Params["block_id"]
+	}
+	entry.Height, _ = strconv.Atoi(eType.Params["height"])
+	entry.Round, _ = strconv.Atoi(eType.Params["round"])
+	return entry, true
+}
+
+func sendDecision(e *types.Event, c *testlib.Context, sent *util.TMessage, delivered []*types.Message) string {
+	id, _ := e.MessageID()
+	for _, m := range delivered {
+		if m.ID == id {
+			return decisionDeliver
+		}
+	}
+	for _, m := range delivered {
+		if m.From == sent.From && m.To == sent.To {
+			return decisionCorrupt
+		}
+	}
+	if getHeldMessages(c).holds(id) {
+		return decisionHold
+	}
+	return decisionDrop
+}
+
+// Time a message is held back for a message that the replayed schedule delivered before it, after which the replay
+// gives up on the order. Replicas time out and diverge from the schedule, and the expected message may never come.
+const replayHoldTimeout = 5 * time.Second
+
+// messageKey identifies a message across runs: the n-th delivered message with these labels, type, height and round
+type messageKey struct {
+	from   string
+	to     string
+	mType  util.MessageType
+	height int
+	round  int
+	nth    int
+}
+
+// sequencer holds back consensus messages until every message that the replayed schedule delivered to the same
+// replica before them has been delivered. Messages that the replayed schedule did not deliver are not held.
+// It also makes the filters decide on sent consensus messages like in the replayed schedule, see replayDecision.
+type sequencer struct {
+	// Keys of the messages delivered to every replica, in order
+	expected map[string][]messageKey
+	// Position of every key in the order of its receiver
+	positions map[messageKey]int
+	// Position of the next message to deliver to every replica
+	next map[string]int
+	// Number of messages seen so far by key, with nth unset
+	seen map[messageKey]int
+	held []heldMessage
+	// Decisions of the filters on the sent messages of the replayed schedule, and the number of sends so far by key
+	decisions map[messageKey]string
+	sent      map[messageKey]int
+	// Corruptions of the instance, to replay corruptions that the filters did not apply
+	corruptions []MessageCorruption
+
+	// Time a message is held before the replay gives up on the order of its receiver
+	holdTimeout time.Duration
+	// Wakes the replay once a held message is due, see InstanceOptions.Wake
+	wake func(replica types.ReplicaID)
+	// Time until which a wake is pending for every replica
+	wakeAt map[types.ReplicaID]time.Time
+}
+
+type heldMessage struct {
+	message *types.Message
+	key     messageKey
+	since   time.Time
+}
+
+func newSequencer(replay *Schedule, corruptions []MessageCorruption, wake func(replica types.ReplicaID)) *sequencer {
+	s := &sequencer{
+		expected:    make(map[string][]messageKey),
+		positions:   make(map[messageKey]int),
+		next:        make(map[string]int),
+		seen:        make(map[messageKey]int),
+		decisions:   make(map[messageKey]string),
+		sent:        make(map[messageKey]int),
+		corruptions: corruptions,
+		holdTimeout: replayHoldTimeout,
+		wake:        wake,
+		wakeAt:      make(map[types.ReplicaID]time.Time),
+	}
+	delivered, sent := make(map[messageKey]int), make(map[messageKey]int)
+	for _, entry := range replay.Entries {
+		if !isConsensusMessageType(entry.Type) {
+			continue
+		}
+		key := entryKey(entry)
+		switch entry.Kind {
+		case scheduleSend:
+			key.nth = sent[key]
+			sent[key]++
+			s.decisions[key] = entry.Decision
+		case scheduleDeliver:
+			key.nth = delivered[key]
+			delivered[key]++
+			s.positions[key] = len(s.expected[entry.To])
+			s.expected[entry.To] = append(s.expected[entry.To], key)
+		}
+	}
+	return s
+}
+
+func entryKey(entry ScheduleEntry) messageKey {
+	return messageKey{from: entry.From, to: entry.To, mType: entry.Type, height: entry.Height, round: entry.Round}
+}
+
+// replayDecision makes the decision of the filters on a sent consensus message the decision of the replayed
+// schedule, and returns the messages to deliver instead of those the filters delivered. Faults that depend on the
+// progress of the replicas, such as drops in a round, can take effect at another time in the replay. Nodes resend
+// votes on their own timers, so sends are matched by their count per sender, receiver, type, height and round.
+//
+// Drops and deliveries are replayed as is. A corruption is replayed with the first corruption of the instance that
+// applies to the message regardless of its round, it cannot be replayed if there is none. Holds are not replayed,
+// delays and reorders release their messages on their own conditions.
+func (s *sequencer) replayDecision(e *types.Event, c *testlib.Context, entry ScheduleEntry, messages []*types.Message) []*types.Message {
+	if !isConsensusMessageType(entry.Type) {
+		return messages
+	}
+	key := entryKey(entry)
+	key.nth = s.sent[key]
+	s.sent[key]++
+	replayed, ok := s.decisions[key]
+	if !ok || replayed == entry.Decision {
+		return messages
+	}
+	logger := c.Logger().With(log.LogParams{
+		"from":     key.from,
+		"to":       key.to,
+		"type":     key.mType,
+		"height":   key.height,
+		"round":    key.round,
+		"decision": entry.Decision,
+		"replayed": replayed,
+	})
+	sent, ok := c.MessagePool.Get(entry.ID)
+	if !ok {
+		return messages
+	}
+	switch replayed {
+	case decisionDrop:
+		getHeldMessages(c).remove(sent.ID)
+		messages = withoutMessage(c, sent, messages)
+	case decisionDeliver:
+		getHeldMessages(c).remove(sent.ID)
+		messages = append(withoutMessage(c, sent, messages), sent)
+	case decisionCorrupt:
+		corrupted, ok := s.corrupt(e, c)
+		if !ok {
+			logger.Info("Replay diverged, no corruption applies to the message")
+			return messages
+		}
+		getHeldMessages(c).remove(sent.ID)
+		messages = append(withoutMessage(c, sent, messages), corrupted...)
+	default:
+		logger.Info("Replay diverged, filters decided differently")
+		return messages
+	}
+	logger.Info("Replayed the decision on the message")
+	return messages
+}
+
+// withoutMessage removes a sent message from the messages to deliver, together with the messages the filters
+// created in its place, which are not in the message pool
+func withoutMessage(c *testlib.Context, sent *types.Message, messages []*types.Message) []*types.Message {
+	kept := make([]*types.Message, 0, len(messages))
+	for _, message := range messages {
+		if message.ID == sent.ID {
+			continue
+		}
+		if _, known := c.MessagePool.Get(message.ID); !known && message.From == sent.From && message.To == sent.To {
+			continue
+		}
+		kept = append(kept, message)
+	}
+	return kept
+}
+
+// corrupt applies the first corruption of the instance that applies to the sent message in any round
+func (s *sequencer) corrupt(e *types.Event, c *testlib.Context) ([]*types.Message, bool) {
+	for _, corruption := range s.corruptions {
+		if corruption.GossipType != "" {
+			continue
+		}
+		cond := common.IsMessageType(corruption.MessageType()).
+			And(common.IsMessageFromPart(nodeLabel(corruption.From)))
+		if !corruption.IsEquivocation() {
+			cond = cond.And(IsMessageToOneOf(corruption.To))
+		}
+		if cond(e, c) {
+			return corruption.Action()(e, c), true
+		}
+	}
+	return nil, false
+}
+
+func isConsensusMessageType(t util.MessageType) bool {
+	return t == util.Proposal || t == util.Prevote || t == util.Precommit
+}
+
+// sequence returns the messages to deliver now, out of the messages the filters delivered and the held messages
+func (s *sequencer) sequence(e *types.Event, c *testlib.Context, messages []*types.Message) []*types.Message {
+	now := time.Now()
+	deliver := make([]*types.Message, 0, len(messages))
+	for _, message := range messages {
+		m, ok := util.GetParsedMessage(message)
+		if !ok || !isConsensusMessageType(m.Type) {
+			deliver = append(deliver, message)
+			continue
+		}
+		key := entryKey(scheduleEntry(c, scheduleDeliver, message.ID, m))
+		key.nth = s.seen[key]
+		s.seen[key]++
+		position, ok := s.positions[key]
+		if !ok || position < s.next[key.to] {
+			if !ok {
+				c.Logger().With(log.LogParams{
+					"from":   key.from,
+					"to":     key.to,
+					"type":   key.mType,
+					"height": key.height,
+					"round":  key.round,
+				}).Info("Replay diverged, message not in the schedule")
+			}
+			deliver = append(deliver, message)
+			continue
+		}
+		s.held = append(s.held, heldMessage{message: message, key: key, since: now})
+	}
+
+	// Give up on the order of a replica once a message to it was held for too long, or once the network heals.
+	// The replay goes on from the first held message of the replica.
+	healed := liveness.IsTestFinished(e, c)
+	stuck := make(map[string]bool)
+	first := make(map[string]int)
+	for _, h := range s.held {
+		if healed || now.Sub(h.since) > s.holdTimeout {
+			stuck[h.key.to] = true
+		}
+		if position, ok := first[h.key.to]; !ok || s.positions[h.key] < position {
+			first[h.key.to] = s.positions[h.key]
+		}
+	}
+	for to := range stuck {
+		c.Logger().With(log.LogParams{
+			"to":      to,
+			"skipped": first[to] - s.next[to],
+		}).Info("Replay diverged, skipping messages that did not arrive")
+		s.next[to] = first[to]
+	}
+
+	for released := true; released; {
+		released = false
+		stillHeld := make([]heldMessage, 0, len(s.held))
+		for _, h := range s.held {
+			// Messages before the next one are late, their place was skipped
+			if position := s.positions[h.key]; position <= s.next[h.key.to] {
+				deliver = append(deliver, h.message)
+				if position == s.next[h.key.to] {
+					s.next[h.key.to]++
+				}
+				released = true
+			} else {
+				stillHeld = append(stillHeld, h)
+			}
+		}
+		s.held = stillHeld
+	}
+	s.armWake(now)
+	return deliver
+}
+
+// armWake wakes the replay once the held messages of a replica are due, unless a wake is pending already.
+// Replicas that wait for a held message may stay silent, and without events the filters would not run.
+func (s *sequencer) armWake(now time.Time) {
+	if s.wake == nil {
+		return
+	}
+	for _, h := range s.held {
+		replica := h.message.To
+		if pending, ok := s.wakeAt[replica]; ok && now.Before(pending) {
+			continue
+		}
+		// Just after the message is due, the wake event takes a moment to arrive as well
+		due := h.since.Add(s.holdTimeout + wakeMargin)
+		s.wakeAt[replica] = due
+		time.AfterFunc(due.Sub(now), func() { s.wake(replica) })
+	}
+}
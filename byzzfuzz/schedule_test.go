@@ -0,0 +1,191 @@
+package byzzfuzz
+
+import (
+	"byzzfuzz/liveness"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+	tmsg "github.com/tendermint/tendermint/proto/tendermint/consensus"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+)
+
+func prevoteKey(from int, to int, nth int) messageKey {
+	return messageKey{from: nodeLabel(from), to: nodeLabel(to), mType: util.Prevote, height: 1, nth: nth}
+}
+
+func prevoteEntry(kind string, from int, to int, decision string) ScheduleEntry {
+	return ScheduleEntry{Kind: kind, From: nodeLabel(from), To: nodeLabel(to), Type: util.Prevote, Height: 1, Decision: decision}
+}
+
+// sequencerTest runs a sequencer on the context of a peerTracker, which labels replicas with their node
+type sequencerTest struct {
+	*peerTracker
+	seq *sequencer
+}
+
+func newSequencerTest(t *testing.T, replay ...ScheduleEntry) *sequencerTest {
+	p := newPeerTracker(t)
+	p.newStep(0, 1, 0)
+	schedule := NewSchedule()
+	for _, entry := range replay {
+		schedule.add(entry)
+	}
+	return &sequencerTest{peerTracker: p, seq: newSequencer(schedule, nil, nil)}
+}
+
+// message adds a message from replica from to replica to to the message pool, a prevote at height 1 unless mType
+// is another type
+func (s *sequencerTest) message(from int, to int, mType util.MessageType) *types.Message {
+	data := &tmsg.Message{Sum: &tmsg.Message_Vote{Vote: &tmsg.Vote{Vote: &tmproto.Vote{Type: tmproto.PrevoteType, Height: 1}}}}
+	if mType != util.Prevote {
+		data = &tmsg.Message{Sum: &tmsg.Message_NewRoundStep{NewRoundStep: &tmsg.NewRoundStep{Height: 1}}}
+	}
+	s.events++
+	message := &types.Message{
+		ID:   fmt.Sprintf("message%d", s.events),
+		From: types.ReplicaID(nodeLabel(from)),
+		To:   types.ReplicaID(nodeLabel(to)),
+		Type: string(mType),
+	}
+	message.ParsedMessage = &util.TMessage{From: message.From, To: message.To, Type: mType, Data: data}
+	s.root.MessageStore.Add(message)
+	return message
+}
+
+func (s *sequencerTest) sequence(messages ...*types.Message) []*types.Message {
+	return s.seq.sequence(nil, s.ctx, messages)
+}
+
+func assertDelivered(t *testing.T, delivered []*types.Message, want ...*types.Message) {
+	t.Helper()
+	ids := func(messages []*types.Message) []string {
+		ids := make([]string, 0, len(messages))
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		return ids
+	}
+	if !reflect.DeepEqual(ids(delivered), ids(want)) {
+		t.Errorf("delivered %v, want %v", ids(delivered), ids(want))
+	}
+}
+
+func TestNewSequencer(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleSend, 0, 1, decisionDeliver),
+		prevoteEntry(scheduleSend, 0, 1, decisionDrop),
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 1, 2, ""),
+		ScheduleEntry{Kind: scheduleDeliver, From: nodeLabel(3), To: nodeLabel(1), Type: util.NewRoundStep, Height: 1},
+	).seq
+
+	wantExpected := map[string][]messageKey{
+		nodeLabel(1): {prevoteKey(0, 1, 0), prevoteKey(2, 1, 0), prevoteKey(0, 1, 1)},
+		nodeLabel(2): {prevoteKey(1, 2, 0)},
+	}
+	if !reflect.DeepEqual(s.expected, wantExpected) {
+		t.Errorf("expected deliveries %v, want %v", s.expected, wantExpected)
+	}
+	wantPositions := map[messageKey]int{
+		prevoteKey(0, 1, 0): 0,
+		prevoteKey(2, 1, 0): 1,
+		prevoteKey(0, 1, 1): 2,
+		prevoteKey(1, 2, 0): 0,
+	}
+	if !reflect.DeepEqual(s.positions, wantPositions) {
+		t.Errorf("positions %v, want %v", s.positions, wantPositions)
+	}
+	wantDecisions := map[messageKey]string{
+		prevoteKey(0, 1, 0): decisionDeliver,
+		prevoteKey(0, 1, 1): decisionDrop,
+	}
+	if !reflect.DeepEqual(s.decisions, wantDecisions) {
+		t.Errorf("decisions %v, want %v", s.decisions, wantDecisions)
+	}
+}
+
+func TestSequenceHoldsMessagesUntilTheirTurn(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+	)
+	first, second := s.message(0, 1, util.Prevote), s.message(2, 1, util.Prevote)
+	assertDelivered(t, s.sequence(second))
+	assertDelivered(t, s.sequence(first), first, second)
+}
+
+func TestSequenceDeliversMessagesOutsideOfTheSchedule(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+	)
+	unscheduled, gossip := s.message(3, 1, util.Prevote), s.message(2, 1, util.NewRoundStep)
+	assertDelivered(t, s.sequence(unscheduled, gossip), unscheduled, gossip)
+}
+
+func TestSequenceReleasesHeldMessagesAfterTheHoldTimeout(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+	)
+	s.seq.holdTimeout = time.Millisecond
+	held := s.message(2, 1, util.Prevote)
+	assertDelivered(t, s.sequence(held))
+	time.Sleep(2 * s.seq.holdTimeout)
+	assertDelivered(t, s.sequence(), held)
+}
+
+func TestSequenceReleasesHeldMessagesOnceTheNetworkHeals(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+	)
+	held := s.message(2, 1, util.Prevote)
+	assertDelivered(t, s.sequence(held))
+	liveness.MarkTestFinished(s.ctx)
+	assertDelivered(t, s.sequence(), held)
+}
+
+func TestSequenceWakesTheReceiverOfHeldMessages(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleDeliver, 0, 1, ""),
+		prevoteEntry(scheduleDeliver, 2, 1, ""),
+	)
+	woken := make(chan types.ReplicaID, 1)
+	s.seq.holdTimeout = time.Millisecond
+	s.seq.wake = func(replica types.ReplicaID) { woken <- replica }
+	assertDelivered(t, s.sequence(s.message(2, 1, util.Prevote)))
+	select {
+	case replica := <-woken:
+		if replica != types.ReplicaID(nodeLabel(1)) {
+			t.Errorf("woke %s, want %s", replica, nodeLabel(1))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("receiver of the held message was not woken")
+	}
+}
+
+func TestReplayDecision(t *testing.T) {
+	s := newSequencerTest(t,
+		prevoteEntry(scheduleSend, 0, 1, decisionDrop),
+		prevoteEntry(scheduleSend, 0, 1, decisionDeliver),
+	)
+	dropped, delivered := s.message(0, 1, util.Prevote), s.message(0, 1, util.Prevote)
+
+	// The filters delivered the message the replayed run dropped, together with a corrupted copy
+	copied := &types.Message{ID: dropped.ID + "_change1", From: dropped.From, To: dropped.To}
+	entry := prevoteEntry(scheduleSend, 0, 1, decisionDeliver)
+	entry.ID = dropped.ID
+	assertDelivered(t, s.seq.replayDecision(nil, s.ctx, entry, []*types.Message{dropped, copied}))
+
+	// The filters dropped the message the replayed run delivered
+	entry = prevoteEntry(scheduleSend, 0, 1, decisionDrop)
+	entry.ID = delivered.ID
+	assertDelivered(t, s.seq.replayDecision(nil, s.ctx, entry, nil), delivered)
+}
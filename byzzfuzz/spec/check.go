@@ -2,54 +2,183 @@ package spec
 
 import (
 	"encoding/json"
-	"log"
-	"os"
-	"os/exec"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
 )
 
-func Check(ch chan Event) bool {
+// Violation of the rule that a replica moves to a height/round once it has received
+// messages for that height/round from f+1 distinct replicas.
+// At most f replicas are faulty, so f+1 senders include a correct one, which is the rule of Tendermint.
+// Check and RoundSkipMonitor both use this threshold.
+type Violation struct {
+	Node   string `json:"node"`
+	Height int    `json:"height"`
+	Round  int    `json:"round"`
+	// The first message received from every sender for the height/round
+	Evidence []MessageEvent `json:"evidence"`
+}
+
+func (v *Violation) String() string {
+	return fmt.Sprintf("%s did not reach height %d round %d after receiving messages from %s",
+		v.Node, v.Height, v.Round, strings.Join(v.senders(), ", "))
+}
+
+func (v *Violation) senders() []string {
+	senders := make([]string, len(v.Evidence))
+	for i, m := range v.Evidence {
+		senders[i] = m.From
+	}
+	return senders
+}
+
+// Collect returns all events sent on the channel so far.
+func Collect(ch chan Event) []Event {
 	// We don't expect more messages
 	close(ch)
 
-	f, err := os.Create("spec.log")
-	if err != nil {
-		log.Fatal(err)
+	events := make([]Event, 0, len(ch))
+	for event := range ch {
+		events = append(events, event)
 	}
-	defer f.Close()
+	return events
+}
 
-	for len(ch) > 0 {
-		event := <-ch
+// WriteLog writes the events as JSON lines.
+func WriteLog(w io.Writer, events []Event) error {
+	for _, event := range events {
 		js, err := json.Marshal(event)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		_, err = w.Write(append(js, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadLog reads events written by WriteLog.
+func ReadLog(r io.Reader) ([]Event, error) {
+	events := make([]Event, 0)
+	decoder := json.NewDecoder(r)
+	for {
+		var fields map[string]json.RawMessage
+		err := decoder.Decode(&fields)
+		if err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, err
 		}
-		_, err = f.Write(js)
+		js, err := json.Marshal(fields)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		_, err = f.WriteString("\n")
+		var event Event = &StepEvent{}
+		if _, ok := fields["From"]; ok {
+			event = &MessageEvent{}
+		}
+		err = json.Unmarshal(js, event)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		events = append(events, event)
 	}
+}
 
-	f.Sync()
+// Check compares the steps every replica is expected to take with the steps it actually took.
+// It returns the expected steps that are missing, ordered by node, height and round.
+func Check(events []Event, faults int) []Violation {
+	violations := make([]Violation, 0)
+	for _, node := range nodes(events) {
+		expected := findExpectedSteps(events, node, faults)
+		actual := findActualSteps(events, node)
+		for _, hr := range sortedHeightRounds(expected) {
+			if !actual[hr] {
+				violations = append(violations, Violation{
+					Node:     node,
+					Height:   hr.height,
+					Round:    hr.round,
+					Evidence: expected[hr],
+				})
+			}
+		}
+	}
+	return violations
+}
 
-	return runAnalysis()
+// Expect a step if we received a message with a given height/round from at least f+1 nodes
+func findExpectedSteps(events []Event, node string, faults int) map[heightRound][]MessageEvent {
+	received := make(map[heightRound][]MessageEvent)
+	for _, e := range events {
+		m, ok := e.(*MessageEvent)
+		if !ok || m.To != node {
+			continue
+		}
+		hr := heightRound{height: m.Height, round: m.Round}
+		seen := false
+		for _, other := range received[hr] {
+			if other.From == m.From {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			received[hr] = append(received[hr], *m)
+		}
+	}
+
+	expected := make(map[heightRound][]MessageEvent)
+	for hr, messages := range received {
+		if len(messages) >= faults+1 {
+			expected[hr] = messages
+		}
+	}
+	return expected
 }
 
-func runAnalysis() bool {
-	cmd := exec.Command("python3", "analyse.py")
-	cmd.Stdout = os.Stdout
-	err := cmd.Run()
-	if err == nil {
-		return true
+func findActualSteps(events []Event, node string) map[heightRound]bool {
+	actual := make(map[heightRound]bool)
+	for _, e := range events {
+		s, ok := e.(*StepEvent)
+		if !ok || s.Replica != node {
+			continue
+		}
+		actual[heightRound{height: s.Height, round: s.Round}] = true
 	}
-	exitErr, ok := err.(*exec.ExitError)
-	if ok {
-		return exitErr.Success()
-	} else {
-		log.Fatalf("Analysis failed to run: %v", err)
-		return false
+	return actual
+}
+
+func nodes(events []Event) []string {
+	seen := make(map[string]bool)
+	for _, e := range events {
+		switch e := e.(type) {
+		case *MessageEvent:
+			seen[e.To] = true
+		case *StepEvent:
+			seen[e.Replica] = true
+		}
+	}
+	nodes := make([]string, 0, len(seen))
+	for node := range seen {
+		nodes = append(nodes, node)
 	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+func sortedHeightRounds(steps map[heightRound][]MessageEvent) []heightRound {
+	hrs := make([]heightRound, 0, len(steps))
+	for hr := range steps {
+		hrs = append(hrs, hr)
+	}
+	sort.Slice(hrs, func(i, j int) bool {
+		if hrs[i].height != hrs[j].height {
+			return hrs[i].height < hrs[j].height
+		}
+		return hrs[i].round < hrs[j].round
+	})
+	return hrs
 }
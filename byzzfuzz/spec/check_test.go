@@ -0,0 +1,42 @@
+package spec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadLogReadsWrittenLog(t *testing.T) {
+	events := []Event{
+		&StepEvent{Replica: "node0", Height: 1, Round: 0},
+		&MessageEvent{From: "node1", To: "node0", Height: 1, Round: 1},
+		&StepEvent{Replica: "node0", Height: 1, Round: 1},
+	}
+	var log strings.Builder
+	if err := WriteLog(&log, events); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadLog(strings.NewReader(log.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, events) {
+		t.Errorf("read %v, wrote %v", read, events)
+	}
+}
+
+func TestCheckExpectsSkipAfterFPlusOneSenders(t *testing.T) {
+	events := []Event{
+		&StepEvent{Replica: "node0", Height: 1, Round: 0},
+		&MessageEvent{From: "node1", To: "node0", Height: 1, Round: 1},
+		&MessageEvent{From: "node1", To: "node0", Height: 1, Round: 2},
+		&MessageEvent{From: "node2", To: "node0", Height: 1, Round: 2},
+	}
+	violations := Check(events, 1)
+	if len(violations) != 1 || violations[0].Node != "node0" || violations[0].Round != 2 {
+		t.Fatalf("got violations %v, want node0 to miss round 2 only", violations)
+	}
+	if senders := violations[0].senders(); !reflect.DeepEqual(senders, []string{"node1", "node2"}) {
+		t.Errorf("got evidence from %v, want node1 and node2", senders)
+	}
+}
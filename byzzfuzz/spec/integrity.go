@@ -0,0 +1,52 @@
+package spec
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+)
+
+// DoubleCommit is true once a replica commits a block at a height where it already committed another block.
+// Unlike DiffCommits, it compares the commits of every replica with its own earlier commits.
+func DoubleCommit(e *types.Event, c *testlib.Context) bool {
+	eType, ok := e.Type.(*types.GenericEventType)
+	if !ok || eType.T != "Committing block" {
+		return false
+	}
+	heightS, ok := eType.Params["height"]
+	if !ok {
+		return false
+	}
+	height, err := strconv.Atoi(heightS)
+	if err != nil {
+		return false
+	}
+	blockID, ok := eType.Params["block_id"]
+	if !ok {
+		return false
+	}
+
+	key := replicaBlockIdKey(e.Replica, height)
+	curBlockID, exists := c.Vars.GetString(key)
+	if !exists {
+		c.Vars.Set(key, blockID)
+		return false
+	}
+	if curBlockID == blockID {
+		return false
+	}
+	c.Logger().With(log.LogParams{
+		"replica":      getPartLabel(c, e.Replica),
+		"height":       height,
+		"cur_block_id": curBlockID,
+		"block_id":     blockID,
+	}).Info("Replica committed a second block at the same height")
+	return true
+}
+
+func replicaBlockIdKey(replica types.ReplicaID, height int) string {
+	return fmt.Sprintf("BF_block_id_%s_height_%d", replica, height)
+}
@@ -37,50 +37,88 @@ type Event interface {
 
 func Log(ch chan Event) testlib.FilterFunc {
 	return func(e *types.Event, ctx *testlib.Context) (ms []*types.Message, handled bool) {
-		// Handle message
-		if testlib.IsMessageReceive()(e, ctx) {
-			message, ok := util.GetMessageFromEvent(e, ctx)
-			if ok {
-				height, round := message.HeightRound()
-				if round >= 0 && (message.Type == util.Prevote || message.Type == util.Precommit) {
-					ch <- &MessageEvent{
-						From:   getPartLabel(ctx, message.From),
-						To:     getPartLabel(ctx, message.To),
-						Height: height,
-						Round:  round,
-					}
-				}
-			}
-		}
-		// Handle step
-		eType, ok := e.Type.(*types.GenericEventType)
-		if !ok {
-			return
-		}
-		if eType.T != "newStep" {
-			return
-		}
-		heightS, ok := eType.Params["height"]
-		if !ok {
-			return
-		}
-		height, err := strconv.Atoi(heightS)
-		if err != nil {
-			return
-		}
-		roundS, ok := eType.Params["round"]
-		if !ok {
-			return
-		}
-		round, err := strconv.Atoi(roundS)
-		if err != nil {
-			return
-		}
-		ch <- &StepEvent{
-			Replica: getPartLabel(ctx, e.Replica),
-			Height:  height,
-			Round:   round,
+		if specEvent, ok := toEvent(e, ctx); ok {
+			ch <- specEvent
 		}
 		return
 	}
 }
+
+// toEvent converts received votes and newStep events, the other events are of no interest to the spec
+func toEvent(e *types.Event, ctx *testlib.Context) (Event, bool) {
+	// Handle message
+	if testlib.IsMessageReceive()(e, ctx) {
+		message, ok := util.GetMessageFromEvent(e, ctx)
+		if ok {
+			height, round := message.HeightRound()
+			if round >= 0 && (message.Type == util.Prevote || message.Type == util.Precommit) {
+				return &MessageEvent{
+					From:   getPartLabel(ctx, message.From),
+					To:     getPartLabel(ctx, message.To),
+					Height: height,
+					Round:  round,
+				}, true
+			}
+		}
+	}
+	// Handle step
+	eType, ok := e.Type.(*types.GenericEventType)
+	if !ok {
+		return nil, false
+	}
+	if eType.T != "newStep" {
+		return nil, false
+	}
+	heightS, ok := eType.Params["height"]
+	if !ok {
+		return nil, false
+	}
+	height, err := strconv.Atoi(heightS)
+	if err != nil {
+		return nil, false
+	}
+	roundS, ok := eType.Params["round"]
+	if !ok {
+		return nil, false
+	}
+	round, err := strconv.Atoi(roundS)
+	if err != nil {
+		return nil, false
+	}
+	return &StepEvent{
+		Replica: getPartLabel(ctx, e.Replica),
+		Height:  height,
+		Round:   round,
+	}, true
+}
+
+// ReplayedMessageType is the type of the generic events that stand in for received messages when the log
+// of a run is replayed, the log does not keep the messages themselves.
+// Their params are the labels from and to, and the type, height and round of the message.
+const ReplayedMessageType = "Replayed message"
+
+// replayedEvent converts the events of a replayed log like toEvent converts the events of a run
+func replayedEvent(e *types.Event, ctx *testlib.Context) (Event, bool) {
+	eType, ok := e.Type.(*types.GenericEventType)
+	if !ok || eType.T != ReplayedMessageType {
+		return toEvent(e, ctx)
+	}
+	messageType := util.MessageType(eType.Params["type"])
+	if messageType != util.Prevote && messageType != util.Precommit {
+		return nil, false
+	}
+	height, err := strconv.Atoi(eType.Params["height"])
+	if err != nil {
+		return nil, false
+	}
+	round, err := strconv.Atoi(eType.Params["round"])
+	if err != nil || round < 0 {
+		return nil, false
+	}
+	return &MessageEvent{
+		From:   eType.Params["from"],
+		To:     eType.Params["to"],
+		Height: height,
+		Round:  round,
+	}, true
+}
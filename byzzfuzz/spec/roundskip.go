@@ -0,0 +1,167 @@
+package spec
+
+import (
+	"sort"
+	"time"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+)
+
+// RoundSkipMonitor checks online that replicas follow the round skip rule: once a replica receives
+// messages for a higher round of its current height from f+1 distinct replicas, it moves to that round.
+// This is the threshold of Violation, which Check uses as well.
+// Replicas get a grace period to do so, since the messages may still be queued when the rule applies.
+type RoundSkipMonitor struct {
+	faults int
+	grace  time.Duration
+
+	replicas  map[string]*replicaRounds
+	violation *Violation
+}
+
+type replicaRounds struct {
+	current heightRound
+	// The first message received from every sender, by height/round
+	received map[heightRound][]MessageEvent
+	// Rounds the replica has to move to, and when that became required
+	expected map[heightRound]time.Time
+}
+
+func NewRoundSkipMonitor(faults int, grace time.Duration) *RoundSkipMonitor {
+	return &RoundSkipMonitor{
+		faults:   faults,
+		grace:    grace,
+		replicas: make(map[string]*replicaRounds),
+	}
+}
+
+func (m *RoundSkipMonitor) replica(label string) *replicaRounds {
+	r, ok := m.replicas[label]
+	if !ok {
+		r = &replicaRounds{
+			current:  heightRound{height: 1, round: 0},
+			received: make(map[heightRound][]MessageEvent),
+			expected: make(map[heightRound]time.Time),
+		}
+		m.replicas[label] = r
+	}
+	return r
+}
+
+// Observe updates the state of the monitor with an event that happened at the given time.
+func (m *RoundSkipMonitor) Observe(e Event, at time.Time) {
+	switch e := e.(type) {
+	case *MessageEvent:
+		r := m.replica(e.To)
+		hr := heightRound{height: e.Height, round: e.Round}
+		for _, other := range r.received[hr] {
+			if other.From == e.From {
+				return
+			}
+		}
+		r.received[hr] = append(r.received[hr], *e)
+		// The rule only applies to higher rounds of the current height, replicas catch up on heights by other means
+		if hr.height != r.current.height || hr.round <= r.current.round {
+			return
+		}
+		if _, ok := r.expected[hr]; !ok && len(r.received[hr]) >= m.faults+1 {
+			r.expected[hr] = at
+		}
+	case *StepEvent:
+		r := m.replica(e.Replica)
+		hr := heightRound{height: e.Height, round: e.Round}
+		if hr.height < r.current.height || (hr.height == r.current.height && hr.round < r.current.round) {
+			return
+		}
+		r.current = hr
+		for expected := range r.expected {
+			if expected.height < hr.height || (expected.height == hr.height && expected.round <= hr.round) {
+				delete(r.expected, expected)
+			}
+		}
+		// Messages for higher rounds may have arrived before the replica reached this height
+		for received, messages := range r.received {
+			_, ok := r.expected[received]
+			if !ok && received.height == hr.height && received.round > hr.round && len(messages) >= m.faults+1 {
+				r.expected[received] = at
+			}
+		}
+	}
+}
+
+// Forget drops what the monitor knows about a replica, for example when the replica crashes.
+// Once it is back, the replica is treated as if it had just started.
+func (m *RoundSkipMonitor) Forget(label string) {
+	delete(m.replicas, label)
+}
+
+// Check returns the first violation of the rule, that is a round skip that is overdue at the given time.
+// Once a violation is found, it is returned by all later checks.
+func (m *RoundSkipMonitor) Check(at time.Time) (Violation, bool) {
+	if m.violation != nil {
+		return *m.violation, true
+	}
+	labels := make([]string, 0, len(m.replicas))
+	for label := range m.replicas {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		r := m.replicas[label]
+		overdue := make([]heightRound, 0)
+		for hr, since := range r.expected {
+			if at.Sub(since) > m.grace {
+				overdue = append(overdue, hr)
+			}
+		}
+		if len(overdue) == 0 {
+			continue
+		}
+		sort.Slice(overdue, func(i, j int) bool { return overdue[i].round < overdue[j].round })
+		hr := overdue[0]
+		m.violation = &Violation{
+			Node:     label,
+			Height:   hr.height,
+			Round:    hr.round,
+			Evidence: r.received[hr][:m.faults+1],
+		}
+		return *m.violation, true
+	}
+	return Violation{}, false
+}
+
+// Condition feeds the events of a test case to the monitor, and is true once the rule is violated.
+func (m *RoundSkipMonitor) Condition() testlib.Condition {
+	return m.condition(toEvent, func(e *types.Event) time.Time { return time.Now() })
+}
+
+// ReplayCondition is Condition for the events of a replayed log, see ReplayedMessageType.
+// Events happen at their timestamp rather than when they are replayed.
+func (m *RoundSkipMonitor) ReplayCondition() testlib.Condition {
+	return m.condition(replayedEvent, func(e *types.Event) time.Time { return time.Unix(0, e.Timestamp) })
+}
+
+func (m *RoundSkipMonitor) condition(
+	convert func(e *types.Event, c *testlib.Context) (Event, bool),
+	timeOf func(e *types.Event) time.Time) testlib.Condition {
+
+	return func(e *types.Event, c *testlib.Context) bool {
+		now := timeOf(e)
+		if specEvent, ok := convert(e, c); ok {
+			m.Observe(specEvent, now)
+		}
+		violation, ok := m.Check(now)
+		if !ok {
+			return false
+		}
+		c.Logger().With(log.LogParams{
+			"node":    violation.Node,
+			"height":  violation.Height,
+			"round":   violation.Round,
+			"senders": violation.senders(),
+		}).Info("Round skip violation: " + violation.String())
+		return true
+	}
+}
@@ -0,0 +1,233 @@
+package spec
+
+import (
+	"testing"
+	"time"
+)
+
+const testGrace = 5 * time.Second
+
+var start = time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// timedEvent is an event of a synthetic event stream, at seconds since the start of the test
+type timedEvent struct {
+	at    int
+	event Event
+}
+
+func msg(at int, from string, to string, height int, round int) timedEvent {
+	return timedEvent{at: at, event: &MessageEvent{From: from, To: to, Height: height, Round: round}}
+}
+
+func step(at int, replica string, height int, round int) timedEvent {
+	return timedEvent{at: at, event: &StepEvent{Replica: replica, Height: height, Round: round}}
+}
+
+func seconds(s int) time.Time {
+	return start.Add(time.Duration(s) * time.Second)
+}
+
+// run feeds the events to a monitor for a cluster with one fault, and checks it after every event and at the end
+func run(events []timedEvent, end int) (Violation, bool) {
+	m := NewRoundSkipMonitor(1, testGrace)
+	for _, e := range events {
+		m.Observe(e.event, seconds(e.at))
+		if v, ok := m.Check(seconds(e.at)); ok {
+			return v, ok
+		}
+	}
+	return m.Check(seconds(end))
+}
+
+func TestNoMessages(t *testing.T) {
+	if v, ok := run(nil, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestSkipInTime(t *testing.T) {
+	events := []timedEvent{
+		step(0, "node0", 1, 0),
+		msg(1, "node1", "node0", 1, 2),
+		msg(2, "node2", "node0", 1, 2),
+		step(4, "node0", 1, 2),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestSkipPastTheRound(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 2),
+		msg(2, "node2", "node0", 1, 2),
+		step(4, "node0", 1, 3),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestSkipToNextHeight(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 2),
+		msg(2, "node2", "node0", 1, 2),
+		step(4, "node0", 2, 0),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestMissedSkip(t *testing.T) {
+	events := []timedEvent{
+		step(0, "node0", 1, 0),
+		msg(1, "node1", "node0", 1, 1),
+		msg(2, "node2", "node0", 1, 1),
+		msg(3, "node3", "node0", 1, 1),
+	}
+	v, ok := run(events, 100)
+	if !ok {
+		t.Fatal("expected a violation")
+	}
+	if v.Node != "node0" || v.Height != 1 || v.Round != 1 {
+		t.Fatalf("violation for the wrong step: %s", v.String())
+	}
+	// Exactly the f+1 senders that made the skip necessary
+	senders := v.senders()
+	if len(senders) != 2 || senders[0] != "node1" || senders[1] != "node2" {
+		t.Fatalf("expected node1 and node2 as evidence, got %v", senders)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 1),
+		msg(2, "node2", "node0", 1, 1),
+	}
+	if v, ok := run(events, 2+int(testGrace/time.Second)); ok {
+		t.Fatalf("violation before the grace period ended: %s", v.String())
+	}
+	if _, ok := run(events, 3+int(testGrace/time.Second)); !ok {
+		t.Fatal("expected a violation after the grace period")
+	}
+}
+
+func TestLateStepIsStillViolation(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 1),
+		msg(2, "node2", "node0", 1, 1),
+		// Observed by a check after the grace period, the step comes too late
+		msg(20, "node0", "node1", 1, 0),
+		step(21, "node0", 1, 1),
+	}
+	if _, ok := run(events, 100); !ok {
+		t.Fatal("expected a violation")
+	}
+}
+
+func TestOnlyFSenders(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 1),
+		// Duplicate messages from the same sender count once
+		msg(2, "node1", "node0", 1, 1),
+		msg(3, "node1", "node0", 1, 1),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestSendersOfDifferentRounds(t *testing.T) {
+	events := []timedEvent{
+		msg(1, "node1", "node0", 1, 1),
+		msg(2, "node2", "node0", 1, 2),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestCurrentAndLowerRounds(t *testing.T) {
+	events := []timedEvent{
+		step(0, "node0", 1, 2),
+		msg(1, "node1", "node0", 1, 1),
+		msg(2, "node2", "node0", 1, 1),
+		msg(3, "node1", "node0", 1, 2),
+		msg(4, "node2", "node0", 1, 2),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestHigherHeight(t *testing.T) {
+	// Replicas catch up on heights by block sync, not by the round skip rule
+	events := []timedEvent{
+		msg(1, "node1", "node0", 2, 0),
+		msg(2, "node2", "node0", 2, 0),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestMessagesBeforeHeight(t *testing.T) {
+	// node0 receives messages for round 1 of height 2 while still at height 1
+	events := []timedEvent{
+		msg(1, "node1", "node0", 2, 1),
+		msg(2, "node2", "node0", 2, 1),
+		step(3, "node0", 2, 0),
+	}
+	v, ok := run(events, 100)
+	if !ok {
+		t.Fatal("expected a violation")
+	}
+	if v.Height != 2 || v.Round != 1 {
+		t.Fatalf("violation for the wrong step: %s", v.String())
+	}
+	if _, ok := run(append(events, step(5, "node0", 2, 1)), 100); ok {
+		t.Fatal("unexpected violation after the skip")
+	}
+}
+
+func TestOtherReplicas(t *testing.T) {
+	// Only node0 received the messages, node1 is not expected to move
+	events := []timedEvent{
+		step(0, "node1", 1, 0),
+		msg(1, "node2", "node0", 1, 1),
+		msg(2, "node3", "node0", 1, 1),
+		step(3, "node0", 1, 1),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestStaleStep(t *testing.T) {
+	// A step event for an earlier round does not move the replica back
+	events := []timedEvent{
+		step(0, "node0", 1, 3),
+		step(1, "node0", 1, 0),
+		msg(2, "node1", "node0", 1, 2),
+		msg(3, "node2", "node0", 1, 2),
+	}
+	if v, ok := run(events, 100); ok {
+		t.Fatalf("unexpected violation: %s", v.String())
+	}
+}
+
+func TestViolationIsSticky(t *testing.T) {
+	m := NewRoundSkipMonitor(1, testGrace)
+	m.Observe(&MessageEvent{From: "node1", To: "node0", Height: 1, Round: 1}, seconds(0))
+	m.Observe(&MessageEvent{From: "node2", To: "node0", Height: 1, Round: 1}, seconds(0))
+	first, ok := m.Check(seconds(10))
+	if !ok {
+		t.Fatal("expected a violation")
+	}
+	m.Observe(&StepEvent{Replica: "node0", Height: 1, Round: 1}, seconds(11))
+	second, ok := m.Check(seconds(12))
+	if !ok || second.Node != first.Node || second.Round != first.Round {
+		t.Fatal("violation was forgotten")
+	}
+}
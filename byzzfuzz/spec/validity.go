@@ -0,0 +1,77 @@
+package spec
+
+import (
+	"strings"
+
+	"github.com/netrixframework/netrix/log"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
+)
+
+const proposalsKey = "BF_spec_proposals"
+
+// Proposal of a block as observed on the network
+type Proposal struct {
+	// Label of the node that signed the proposal
+	Proposer string
+	// False if the proposal was produced by a corruption, rather than sent by the proposer itself
+	Genuine bool
+}
+
+// RecordProposal notes a proposal of the block with the given hash, for the validity check.
+func RecordProposal(c *testlib.Context, blockHash string, proposal Proposal) {
+	proposalsR, ok := c.Vars.Get(proposalsKey)
+	if !ok {
+		proposalsR = make(map[string][]Proposal)
+		c.Vars.Set(proposalsKey, proposalsR)
+	}
+	proposals := proposalsR.(map[string][]Proposal)
+	key := strings.ToUpper(blockHash)
+	for _, p := range proposals[key] {
+		if p == proposal {
+			return
+		}
+	}
+	proposals[key] = append(proposals[key], proposal)
+}
+
+// InvalidCommit is true for a commit of a block that was never proposed, or only proposed by faulty nodes
+// through corrupted messages. The faulty nodes run the correct implementation, so the blocks they propose
+// themselves are valid.
+func InvalidCommit(faulty []string) testlib.Condition {
+	return func(e *types.Event, c *testlib.Context) bool {
+		eType, ok := e.Type.(*types.GenericEventType)
+		if !ok || eType.T != "Committing block" {
+			return false
+		}
+		blockID, ok := eType.Params["block_id"]
+		if !ok || blockID == "" {
+			return false
+		}
+
+		var proposals []Proposal
+		if proposalsR, ok := c.Vars.Get(proposalsKey); ok {
+			proposals = proposalsR.(map[string][]Proposal)[strings.ToUpper(blockID)]
+		}
+		for _, p := range proposals {
+			if p.Genuine || !contains(faulty, p.Proposer) {
+				return false
+			}
+		}
+		c.Logger().With(log.LogParams{
+			"height":    eType.Params["height"],
+			"block_id":  blockID,
+			"proposals": proposals,
+		}).Info("Commit of a block that no correct node proposed")
+		return true
+	}
+}
+
+func contains(labels []string, label string) bool {
+	for _, l := range labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+---- MODULE ByzzFuzzTrace ----
+\* Height 1 of a ByzzFuzz run. Processes: f1 = node0 c1 = node1 c2 = node2 c3 = node3
+\* v1 = AAAA
+\* x1 = BBBB
+EXTENDS TendermintAcc_004_draft, Sequences
+
+\* @type: Int;
+CONSTANT TraceTarget
+
+\* Number of trace steps matched so far
+\* @type: Int;
+VARIABLE i
+
+TraceCorr == {"c1", "c2", "c3"}
+TraceFaulty == {"f1"}
+TraceValidValues == {"v1"}
+TraceInvalidValues == {"x1"}
+TraceProposer == [r \in Rounds |-> <<"c1">>[r + 1]]
+
+TraceFaultyProposals == {}
+TraceFaultyPrevotes == {
+        [type |-> "PREVOTE", src |-> "f1", round |-> 0, id |-> NilValue]
+    }
+TraceFaultyPrecommits == {
+        [type |-> "PRECOMMIT", src |-> "f1", round |-> 0, id |-> "x1"]
+    }
+
+Trace == <<
+    \* 1: node1 proposes v1 in round 0
+    [process |-> "c1", round |-> 0, step |-> "PROPOSE", decision |-> NilValue,
+     proposals |-> {[type |-> "PROPOSAL", src |-> "c1", round |-> 0, proposal |-> "v1", validRound |-> -1]},
+     prevotes |-> {},
+     precommits |-> {}],
+    \* 2: node1 prevotes v1 in round 0
+    [process |-> "c1", round |-> 0, step |-> "PREVOTE", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {[type |-> "PREVOTE", src |-> "c1", round |-> 0, id |-> "v1"]},
+     precommits |-> {}],
+    \* 3: node2 prevotes v1 in round 0
+    [process |-> "c2", round |-> 0, step |-> "PREVOTE", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {[type |-> "PREVOTE", src |-> "c2", round |-> 0, id |-> "v1"]},
+     precommits |-> {}],
+    \* 4: node3 prevotes v1 in round 0
+    [process |-> "c3", round |-> 0, step |-> "PREVOTE", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {[type |-> "PREVOTE", src |-> "c3", round |-> 0, id |-> "v1"]},
+     precommits |-> {}],
+    \* 5: node1 enters PRECOMMIT in round 0 and precommits v1
+    [process |-> "c1", round |-> 0, step |-> "PRECOMMIT", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {[type |-> "PRECOMMIT", src |-> "c1", round |-> 0, id |-> "v1"]}],
+    \* 6: node2 enters PRECOMMIT in round 0 and precommits v1
+    [process |-> "c2", round |-> 0, step |-> "PRECOMMIT", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {[type |-> "PRECOMMIT", src |-> "c2", round |-> 0, id |-> "v1"]}],
+    \* 7: node3 enters PRECOMMIT in round 0 and precommits v1
+    [process |-> "c3", round |-> 0, step |-> "PRECOMMIT", decision |-> NilValue,
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {[type |-> "PRECOMMIT", src |-> "c3", round |-> 0, id |-> "v1"]}],
+    \* 8: node1 enters DECIDED in round 0 and decides v1
+    [process |-> "c1", round |-> 0, step |-> "DECIDED", decision |-> "v1",
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {}],
+    \* 9: node2 enters DECIDED in round 0 and decides v1
+    [process |-> "c2", round |-> 0, step |-> "DECIDED", decision |-> "v1",
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {}],
+    \* 10: node3 enters DECIDED in round 0 and decides v1
+    [process |-> "c3", round |-> 0, step |-> "DECIDED", decision |-> "v1",
+     proposals |-> {},
+     prevotes |-> {},
+     precommits |-> {}]
+>>
+
+\* The messages of faulty processes are fixed to the ones they sent in the run
+TraceInit ==
+    /\ i = 0
+    /\ msgsPropose = [r \in Rounds |-> {m \in TraceFaultyProposals: m.round = r}]
+    /\ msgsPrevote = [r \in Rounds |-> {m \in TraceFaultyPrevotes: m.round = r}]
+    /\ msgsPrecommit = [r \in Rounds |-> {m \in TraceFaultyPrecommits: m.round = r}]
+    /\ Init
+
+\* The state of the process of a trace step after an action
+Observed(e) ==
+    /\ round'[e.process] = e.round
+    /\ step'[e.process] = e.step
+    /\ decision'[e.process] = e.decision
+    /\ \A m \in e.proposals: m \in msgsPropose'[m.round]
+    /\ \A m \in e.prevotes: m \in msgsPrevote'[m.round]
+    /\ \A m \in e.precommits: m \in msgsPrecommit'[m.round]
+
+\* Actions that change none of the observed variables, such as updating the valid value, match no trace step
+TraceNext ==
+    \/ /\ i < Len(Trace)
+       /\ Next
+       /\ i' = i + 1
+       /\ Observed(Trace[i + 1])
+    \/ /\ Next
+       /\ UNCHANGED <<i, round, step, decision, msgsPropose, msgsPrevote, msgsPrecommit>>
+
+TraceIncomplete == i < TraceTarget
+====
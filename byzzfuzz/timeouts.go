@@ -0,0 +1,91 @@
+package byzzfuzz
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ConsensusTimeouts overrides the consensus timeouts of a node. Zero durations keep the setting of the node.
+// The deltas are added to their timeout once per round.
+type ConsensusTimeouts struct {
+	Node           int           `json:"node"`
+	Propose        time.Duration `json:"propose,omitempty"`
+	ProposeDelta   time.Duration `json:"propose_delta,omitempty"`
+	Prevote        time.Duration `json:"prevote,omitempty"`
+	PrevoteDelta   time.Duration `json:"prevote_delta,omitempty"`
+	Precommit      time.Duration `json:"precommit,omitempty"`
+	PrecommitDelta time.Duration `json:"precommit_delta,omitempty"`
+	Commit         time.Duration `json:"commit,omitempty"`
+}
+
+// Settings returns the overridden timeouts by their name in the consensus section of the Tendermint config
+func (t *ConsensusTimeouts) Settings() map[string]time.Duration {
+	settings := make(map[string]time.Duration)
+	for name, d := range map[string]time.Duration{
+		"timeout_propose":         t.Propose,
+		"timeout_propose_delta":   t.ProposeDelta,
+		"timeout_prevote":         t.Prevote,
+		"timeout_prevote_delta":   t.PrevoteDelta,
+		"timeout_precommit":       t.Precommit,
+		"timeout_precommit_delta": t.PrecommitDelta,
+		"timeout_commit":          t.Commit,
+	} {
+		if d > 0 {
+			settings[name] = d
+		}
+	}
+	return settings
+}
+
+func validateTimeouts(n int, timeouts []ConsensusTimeouts) error {
+	seen := make(map[int]bool)
+	for _, t := range timeouts {
+		if t.Node < 0 || t.Node >= n {
+			return fmt.Errorf("timeouts of node %d, which does not exist in a cluster of %d nodes", t.Node, n)
+		}
+		if seen[t.Node] {
+			return fmt.Errorf("timeouts of node %d are set twice", t.Node)
+		}
+		seen[t.Node] = true
+		for name, d := range map[string]time.Duration{
+			"propose":         t.Propose,
+			"propose_delta":   t.ProposeDelta,
+			"prevote":         t.Prevote,
+			"prevote_delta":   t.PrevoteDelta,
+			"precommit":       t.Precommit,
+			"precommit_delta": t.PrecommitDelta,
+			"commit":          t.Commit,
+		} {
+			if d < 0 {
+				return fmt.Errorf("negative %s timeout of node %d", name, t.Node)
+			}
+		}
+	}
+	return nil
+}
+
+// randomDuration returns a multiple of 100ms in [min, max]
+func randomDuration(r *rand.Rand, min time.Duration, max time.Duration) time.Duration {
+	const unit = 100 * time.Millisecond
+	return min + time.Duration(r.Int63n(int64((max-min)/unit)+1))*unit
+}
+
+// randomTimeouts samples the timeouts of every node around the Tendermint defaults,
+// from much shorter than a message takes through the testing server to several times the default
+func randomTimeouts(n int, r *rand.Rand) []ConsensusTimeouts {
+	timeouts := make([]ConsensusTimeouts, n)
+	for i := range timeouts {
+		timeouts[i] = ConsensusTimeouts{
+			Node:           i,
+			Propose:        randomDuration(r, 500*time.Millisecond, 10*time.Second),
+			ProposeDelta:   randomDuration(r, 0, 2*time.Second),
+			Prevote:        randomDuration(r, 100*time.Millisecond, 5*time.Second),
+			PrevoteDelta:   randomDuration(r, 0, 2*time.Second),
+			Precommit:      randomDuration(r, 100*time.Millisecond, 5*time.Second),
+			PrecommitDelta: randomDuration(r, 0, 2*time.Second),
+			Commit:         randomDuration(r, 100*time.Millisecond, 10*time.Second),
+		}
+	}
+	return timeouts
+}
@@ -0,0 +1,525 @@
+package byzzfuzz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+// TLASpecModule is the published Tendermint TLA+ spec that trace modules extend, from
+// github.com/tendermint/spec/tree/master/spec/light-client/accountability
+const TLASpecModule = "TendermintAcc_004_draft"
+
+// TLATraceModule is the name of the trace modules, their files must be named after it
+const TLATraceModule = "ByzzFuzzTrace"
+
+// tlaMessage is a message of the spec: PROPOSAL, PREVOTE or PRECOMMIT
+type tlaMessage struct {
+	mType string
+	src   string
+	round int
+	// Value name, or NilValue
+	value      string
+	validRound int
+}
+
+func (m tlaMessage) String() string {
+	if m.mType == "PROPOSAL" {
+		return fmt.Sprintf(`[type |-> "PROPOSAL", src |-> "%s", round |-> %d, proposal |-> %s, validRound |-> %d]`,
+			m.src, m.round, m.value, m.validRound)
+	}
+	return fmt.Sprintf(`[type |-> "%s", src |-> "%s", round |-> %d, id |-> %s]`, m.mType, m.src, m.round, m.value)
+}
+
+// TLAStep is a step of a correct process in a trace: the round, step and decision of the process after an action,
+// and the message the action broadcast
+type TLAStep struct {
+	Process  string
+	Round    int
+	Step     string
+	Decision string
+	message  *tlaMessage
+	// What the node did in the run
+	Description string
+}
+
+func (s TLAStep) messages(mType string) string {
+	if s.message == nil || s.message.mType != mType {
+		return "{}"
+	}
+	return "{" + s.message.String() + "}"
+}
+
+// TLATrace is a height of a run as a trace of the Tendermint TLA+ spec. Correct nodes are the processes c1, c2...
+// and faulty nodes f1, f2..., in the order of their labels. Proposed block hashes are the valid values v1, v2...,
+// other hashes that faulty nodes vote for are the invalid values x1, x2...
+type TLATrace struct {
+	Height int
+	Steps  []TLAStep
+	// Process names by node label
+	Processes map[string]string
+	// Block hashes by value name
+	Values        map[string]string
+	n             int
+	f             int
+	corr          []string
+	faulty        []string
+	validValues   []string
+	invalidValues []string
+	maxRound      int
+	proposers     map[int]string
+	// Messages of faulty nodes, which the spec puts in the message sets initially
+	faultyMessages []tlaMessage
+}
+
+// Spec steps by the step names of replicas. New heights are not in the spec.
+var tlaSteps = map[string]string{
+	"NewRound":      "PROPOSE",
+	"Propose":       "PROPOSE",
+	"Prevote":       "PREVOTE",
+	"PrevoteWait":   "PREVOTE",
+	"Precommit":     "PRECOMMIT",
+	"PrecommitWait": "PRECOMMIT",
+	"Commit":        "DECIDED",
+}
+
+var tlaMessageTypes = map[util.MessageType]string{
+	util.Proposal:  "PROPOSAL",
+	util.Prevote:   "PREVOTE",
+	util.Precommit: "PRECOMMIT",
+}
+
+var tlaVerbs = map[string]string{
+	"PROPOSAL":  "proposes",
+	"PREVOTE":   "prevotes",
+	"PRECOMMIT": "precommits",
+}
+
+// NewTLATrace maps a height of a recorded run to the variables of the spec. Every new step of a correct node, and
+// every proposal it sends, is a step of the trace. The first vote of a node in a round is broadcast by the step
+// that entered the vote's step, as in the spec. Steps that do not come after the current step of a node are
+// skipped, since replicas can report a step after the vote it sent.
+func NewTLATrace(s *Schedule, n int, faulty []int, height int) (*TLATrace, error) {
+	t := &TLATrace{
+		Height:    height,
+		Processes: make(map[string]string),
+		Values:    make(map[string]string),
+		n:         n,
+		f:         (n - 1) / 3,
+		proposers: make(map[int]string),
+	}
+	for i := 0; i < n; i++ {
+		if partContains(faulty, i) {
+			t.faulty = append(t.faulty, fmt.Sprintf("f%d", len(t.faulty)+1))
+			t.Processes[nodeLabel(i)] = t.faulty[len(t.faulty)-1]
+		} else {
+			t.corr = append(t.corr, fmt.Sprintf("c%d", len(t.corr)+1))
+			t.Processes[nodeLabel(i)] = t.corr[len(t.corr)-1]
+		}
+	}
+
+	entries := make([]ScheduleEntry, 0)
+	for _, entry := range s.Entries {
+		if entry.Height == height {
+			entries = append(entries, entry)
+		}
+	}
+	names := make(map[string]string)
+	for _, entry := range entries {
+		if entry.Type == util.Proposal && entry.Value != "" && names[entry.Value] == "" {
+			t.validValues = append(t.validValues, fmt.Sprintf("v%d", len(t.validValues)+1))
+			names[entry.Value] = t.validValues[len(t.validValues)-1]
+		}
+	}
+	if len(t.validValues) == 0 {
+		return nil, fmt.Errorf("no proposals at height %d", height)
+	}
+	for _, entry := range entries {
+		if entry.Value != "" && names[entry.Value] == "" {
+			t.invalidValues = append(t.invalidValues, fmt.Sprintf("x%d", len(t.invalidValues)+1))
+			names[entry.Value] = t.invalidValues[len(t.invalidValues)-1]
+		}
+	}
+	for hash, name := range names {
+		t.Values[name] = hash
+	}
+	value := func(hash string) string {
+		if hash == "" {
+			return "NilValue"
+		}
+		return `"` + names[hash] + `"`
+	}
+
+	// Index of the last step of every correct process, and the messages it sent
+	last := make(map[string]int)
+	sent := make(map[tlaMessage]bool)
+	faultySent := make(map[tlaMessage]bool)
+	state := func(p string) TLAStep {
+		if i, ok := last[p]; ok {
+			step := t.Steps[i]
+			step.message = nil
+			return step
+		}
+		return TLAStep{Process: p, Round: 0, Step: "PROPOSE", Decision: "NilValue"}
+	}
+	addStep := func(step TLAStep) {
+		last[step.Process] = len(t.Steps)
+		t.Steps = append(t.Steps, step)
+	}
+	for _, entry := range entries {
+		if entry.Round > t.maxRound {
+			t.maxRound = entry.Round
+		}
+		p, ok := t.Processes[entry.From]
+		if !ok {
+			continue
+		}
+		correct := !partContains(faulty, nodeIndex(entry.From))
+		cur := state(p)
+		switch entry.Kind {
+		case scheduleStep:
+			step, ok := tlaSteps[entry.Step]
+			if !correct || !ok || !tlaAfter(entry.Round, step, cur) {
+				continue
+			}
+			cur.Round, cur.Step = entry.Round, step
+			cur.Description = fmt.Sprintf("%s enters %s in round %d", entry.From, step, entry.Round)
+			addStep(cur)
+		case scheduleCommit:
+			if !correct || cur.Decision != "NilValue" {
+				continue
+			}
+			if i, ok := last[p]; ok && t.Steps[i].Step == "DECIDED" {
+				t.Steps[i].Decision = value(entry.Value)
+				t.Steps[i].Description += fmt.Sprintf(" and decides %s", names[entry.Value])
+				continue
+			}
+			cur.Step, cur.Decision = "DECIDED", value(entry.Value)
+			cur.Description = fmt.Sprintf("%s decides %s", entry.From, names[entry.Value])
+			addStep(cur)
+		case scheduleSend, scheduleDeliver:
+			mType, ok := tlaMessageTypes[entry.Type]
+			if !ok {
+				continue
+			}
+			m := tlaMessage{mType: mType, src: p, round: entry.Round, value: value(entry.Value), validRound: -1}
+			if mType == "PROPOSAL" {
+				m.validRound = entry.ValidRound
+				if _, ok := t.proposers[m.round]; !ok {
+					t.proposers[m.round] = p
+				}
+			}
+			if !correct {
+				if !faultySent[m] {
+					faultySent[m] = true
+					t.faultyMessages = append(t.faultyMessages, m)
+				}
+				continue
+			}
+			// Nodes send their messages to every other node, and resend votes
+			first := tlaMessage{mType: m.mType, src: m.src, round: m.round}
+			if entry.Kind != scheduleSend || sent[first] {
+				continue
+			}
+			sent[first] = true
+			action := tlaVerbs[mType] + " " + describeValue(names, entry.Value)
+			if i, ok := last[p]; ok && mType != "PROPOSAL" && t.Steps[i].message == nil && t.Steps[i].Round == m.round && t.Steps[i].Step == mType {
+				t.Steps[i].message = &m
+				t.Steps[i].Description += " and " + action
+				continue
+			}
+			// Votes can be recorded before the step that sent them
+			if mType != "PROPOSAL" && tlaAfter(m.round, mType, cur) {
+				cur.Round, cur.Step = m.round, mType
+			}
+			cur.message = &m
+			cur.Description = fmt.Sprintf("%s %s in round %d", entry.From, action, entry.Round)
+			addStep(cur)
+		}
+	}
+	return t, nil
+}
+
+var tlaStepOrder = map[string]int{"PROPOSE": 0, "PREVOTE": 1, "PRECOMMIT": 2, "DECIDED": 3}
+
+// tlaAfter returns whether a step in a round comes after the current step of a process
+func tlaAfter(round int, step string, cur TLAStep) bool {
+	if cur.Step == "DECIDED" {
+		return false
+	}
+	return round > cur.Round || (round == cur.Round && tlaStepOrder[step] > tlaStepOrder[cur.Step])
+}
+
+func describeValue(names map[string]string, hash string) string {
+	if hash == "" {
+		return "nil"
+	}
+	return names[hash]
+}
+
+func nodeIndex(label string) int {
+	var idx int
+	if _, err := fmt.Sscanf(label, "node%d", &idx); err != nil {
+		return -1
+	}
+	return idx
+}
+
+func tlaStrings(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = `"` + v + `"`
+	}
+	return "{" + strings.Join(quoted, ", ") + "}"
+}
+
+func tlaMessageSet(messages []tlaMessage, mType string) string {
+	set := make([]string, 0)
+	for _, m := range messages {
+		if m.mType == mType {
+			set = append(set, "\n        "+m.String())
+		}
+	}
+	if len(set) == 0 {
+		return "{}"
+	}
+	return "{" + strings.Join(set, ",") + "\n    }"
+}
+
+// WriteModule writes the trace module. TraceInit and TraceNext follow the trace with the actions of the spec,
+// TraceIncomplete is violated once the first TraceTarget steps of the trace have been matched.
+func (t *TLATrace) WriteModule(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "---- MODULE %s ----\n", TLATraceModule)
+	fmt.Fprintf(bw, "\\* Height %d of a ByzzFuzz run. Processes:", t.Height)
+	labels := make([]string, 0, len(t.Processes))
+	for label := range t.Processes {
+		labels = append(labels, label)
+	}
+	sortNodeLabels(labels)
+	for _, label := range labels {
+		fmt.Fprintf(bw, " %s = %s", t.Processes[label], label)
+	}
+	fmt.Fprintln(bw)
+	values := make([]string, 0, len(t.Values))
+	for name := range t.Values {
+		values = append(values, name)
+	}
+	sort.Strings(values)
+	for _, name := range values {
+		fmt.Fprintf(bw, "\\* %s = %s\n", name, t.Values[name])
+	}
+	fmt.Fprintf(bw, "EXTENDS %s, Sequences\n\n", TLASpecModule)
+	fmt.Fprint(bw, "\\* @type: Int;\nCONSTANT TraceTarget\n\n\\* Number of trace steps matched so far\n\\* @type: Int;\nVARIABLE i\n\n")
+
+	fmt.Fprintf(bw, "TraceCorr == %s\n", tlaStrings(t.corr))
+	fmt.Fprintf(bw, "TraceFaulty == %s\n", tlaStrings(t.faulty))
+	fmt.Fprintf(bw, "TraceValidValues == %s\n", tlaStrings(t.validValues))
+	fmt.Fprintf(bw, "TraceInvalidValues == %s\n", tlaStrings(t.invalidValues))
+	// Rounds without a proposal in the run take turns, in the order of the node labels
+	all := append(append([]string{}, t.corr...), t.faulty...)
+	order := make([]string, len(labels))
+	for i, label := range labels {
+		order[i] = t.Processes[label]
+	}
+	proposers := make([]string, t.maxRound+1)
+	for r := range proposers {
+		p, ok := t.proposers[r]
+		if !ok {
+			p = order[r%len(all)]
+		}
+		proposers[r] = `"` + p + `"`
+	}
+	fmt.Fprintf(bw, "TraceProposer == [r \\in Rounds |-> <<%s>>[r + 1]]\n\n", strings.Join(proposers, ", "))
+
+	fmt.Fprintf(bw, "TraceFaultyProposals == %s\n", tlaMessageSet(t.faultyMessages, "PROPOSAL"))
+	fmt.Fprintf(bw, "TraceFaultyPrevotes == %s\n", tlaMessageSet(t.faultyMessages, "PREVOTE"))
+	fmt.Fprintf(bw, "TraceFaultyPrecommits == %s\n\n", tlaMessageSet(t.faultyMessages, "PRECOMMIT"))
+
+	fmt.Fprint(bw, "Trace == <<\n")
+	for k, step := range t.Steps {
+		fmt.Fprintf(bw, "    \\* %d: %s\n", k+1, step.Description)
+		fmt.Fprintf(bw, "    [process |-> \"%s\", round |-> %d, step |-> \"%s\", decision |-> %s,\n", step.Process, step.Round, step.Step, step.Decision)
+		fmt.Fprintf(bw, "     proposals |-> %s,\n     prevotes |-> %s,\n     precommits |-> %s]",
+			step.messages("PROPOSAL"), step.messages("PREVOTE"), step.messages("PRECOMMIT"))
+		if k < len(t.Steps)-1 {
+			fmt.Fprint(bw, ",")
+		}
+		fmt.Fprintln(bw)
+	}
+	fmt.Fprint(bw, ">>\n\n")
+
+	fmt.Fprint(bw, `\* The messages of faulty processes are fixed to the ones they sent in the run
+TraceInit ==
+    /\ i = 0
+    /\ msgsPropose = [r \in Rounds |-> {m \in TraceFaultyProposals: m.round = r}]
+    /\ msgsPrevote = [r \in Rounds |-> {m \in TraceFaultyPrevotes: m.round = r}]
+    /\ msgsPrecommit = [r \in Rounds |-> {m \in TraceFaultyPrecommits: m.round = r}]
+    /\ Init
+
+\* The state of the process of a trace step after an action
+Observed(e) ==
+    /\ round'[e.process] = e.round
+    /\ step'[e.process] = e.step
+    /\ decision'[e.process] = e.decision
+    /\ \A m \in e.proposals: m \in msgsPropose'[m.round]
+    /\ \A m \in e.prevotes: m \in msgsPrevote'[m.round]
+    /\ \A m \in e.precommits: m \in msgsPrecommit'[m.round]
+
+\* Actions that change none of the observed variables, such as updating the valid value, match no trace step
+TraceNext ==
+    \/ /\ i < Len(Trace)
+       /\ Next
+       /\ i' = i + 1
+       /\ Observed(Trace[i + 1])
+    \/ /\ Next
+       /\ UNCHANGED <<i, round, step, decision, msgsPropose, msgsPrevote, msgsPrecommit>>
+
+TraceIncomplete == i < TraceTarget
+====
+`)
+	return bw.Flush()
+}
+
+// WriteConfig writes the model config, for a check that the first target steps of the trace conform to the spec
+func (t *TLATrace) WriteConfig(w io.Writer, target int) error {
+	_, err := fmt.Fprintf(w, `INIT TraceInit
+NEXT TraceNext
+CONSTANTS
+    N = %d
+    T = %d
+    MaxRound = %d
+    TraceTarget = %d
+    Corr <- TraceCorr
+    Faulty <- TraceFaulty
+    ValidValues <- TraceValidValues
+    InvalidValues <- TraceInvalidValues
+    Proposer <- TraceProposer
+INVARIANT TraceIncomplete
+`, t.n, t.f, t.maxRound, target)
+	return err
+}
+
+// TLAChecker checks trace modules with TLC, or with Apalache
+type TLAChecker struct {
+	Java string
+	// tla2tools.jar, or the Apalache jar
+	Jar      string
+	Apalache bool
+	// Directory of the spec module that trace modules extend
+	SpecDir string
+}
+
+// TLAResult is the outcome of a check
+type TLAResult struct {
+	// Number of trace steps that the spec can follow, all of them if the trace conforms.
+	// -1 if not even the initial state conforms.
+	Conforming int
+	// The first step that does not conform, if any
+	Failed *TLAStep
+}
+
+// Check finds the longest prefix of the trace that conforms to the spec, by a binary search over the number of
+// steps to match. Checks run in dir, next to a copy of the files of the spec directory, since the spec module
+// extends modules next to it.
+func (c TLAChecker) Check(t *TLATrace, dir string) (TLAResult, error) {
+	files, err := os.ReadDir(c.SpecDir)
+	if err != nil {
+		return TLAResult{}, err
+	}
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(c.SpecDir, file.Name()))
+		if err != nil {
+			return TLAResult{}, err
+		}
+		err = os.WriteFile(filepath.Join(dir, file.Name()), content, 0644)
+		if err != nil {
+			return TLAResult{}, err
+		}
+	}
+	module, err := os.Create(filepath.Join(dir, TLATraceModule+".tla"))
+	if err != nil {
+		return TLAResult{}, err
+	}
+	err = t.WriteModule(module)
+	module.Close()
+	if err != nil {
+		return TLAResult{}, err
+	}
+
+	// Whether the first target steps conform: the invariant that fewer steps were matched is violated
+	matches := func(target int) (bool, error) {
+		cfg, err := os.Create(filepath.Join(dir, TLATraceModule+".cfg"))
+		if err != nil {
+			return false, err
+		}
+		err = t.WriteConfig(cfg, target)
+		cfg.Close()
+		if err != nil {
+			return false, err
+		}
+		return c.violated(dir, target)
+	}
+
+	ok, err := matches(0)
+	if err != nil || !ok {
+		// Not even the initial state conforms, the faulty messages are not messages of the spec
+		return TLAResult{Conforming: -1}, err
+	}
+	low, high := 0, len(t.Steps)
+	if ok, err := matches(high); err != nil || ok {
+		return TLAResult{Conforming: high}, err
+	}
+	for high-low > 1 {
+		mid := (low + high) / 2
+		ok, err := matches(mid)
+		if err != nil {
+			return TLAResult{}, err
+		}
+		if ok {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	return TLAResult{Conforming: low, Failed: &t.Steps[low]}, nil
+}
+
+// violated runs the model checker on the trace module in dir, and returns whether TraceIncomplete is violated
+func (c TLAChecker) violated(dir string, target int) (bool, error) {
+	var cmd *exec.Cmd
+	violation, success := "Invariant TraceIncomplete is violated", "No error has been found"
+	if c.Apalache {
+		// Every trace step can take an action that changes no observed variable before it
+		cmd = exec.Command(c.Java, "-jar", c.Jar, "check", "--config="+TLATraceModule+".cfg",
+			fmt.Sprintf("--length=%d", 2*target+2), TLATraceModule+".tla")
+		violation, success = "The outcome is: Error", "The outcome is: NoError"
+	} else {
+		cmd = exec.Command(c.Java, "-cp", c.Jar, "tlc2.TLC", "-deadlock", "-metadir", "states",
+			"-config", TLATraceModule+".cfg", TLATraceModule+".tla")
+	}
+	cmd.Dir = dir
+	out, _ := cmd.CombinedOutput()
+	switch {
+	case strings.Contains(string(out), violation):
+		return true, nil
+	case strings.Contains(string(out), success):
+		return false, nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > 20 {
+		lines = lines[len(lines)-20:]
+	}
+	return false, errors.New("the model checker failed:\n" + strings.Join(lines, "\n"))
+}
@@ -0,0 +1,93 @@
+package byzzfuzz
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of the tests")
+
+// tlaSchedule is a height in which node1 proposes, faulty node0 prevotes nil and precommits another block, and the
+// correct nodes decide in round 0
+func tlaSchedule() *Schedule {
+	s := NewSchedule()
+	step := func(node string, round int, step string) {
+		s.Entries = append(s.Entries, ScheduleEntry{Kind: scheduleStep, From: node, Height: 1, Round: round, Step: step})
+	}
+	send := func(from string, mType util.MessageType, round int, value string) {
+		for _, to := range []string{"node0", "node1", "node2", "node3"} {
+			if to != from {
+				s.Entries = append(s.Entries, ScheduleEntry{Kind: scheduleSend, From: from, To: to, Type: mType, Height: 1, Round: round, Value: value, ValidRound: -1})
+			}
+		}
+	}
+	for _, node := range []string{"node0", "node1", "node2", "node3"} {
+		step(node, 0, "NewHeight")
+		step(node, 0, "Propose")
+	}
+	send("node1", util.Proposal, 0, "AAAA")
+	send("node0", util.Prevote, 0, "")
+	for _, node := range []string{"node1", "node2", "node3"} {
+		// Votes can be recorded before the step
+		send(node, util.Prevote, 0, "AAAA")
+		step(node, 0, "Prevote")
+	}
+	send("node0", util.Precommit, 0, "BBBB")
+	for _, node := range []string{"node1", "node2", "node3"} {
+		step(node, 0, "Precommit")
+		send(node, util.Precommit, 0, "AAAA")
+		// Resent votes are not steps
+		send(node, util.Precommit, 0, "AAAA")
+	}
+	for _, node := range []string{"node1", "node2", "node3"} {
+		step(node, 0, "Commit")
+		s.Entries = append(s.Entries, ScheduleEntry{Kind: scheduleCommit, From: node, Height: 1, Round: 0, Value: "AAAA"})
+	}
+	// Other heights are not exported
+	step("node1", 0, "NewHeight")
+	s.Entries = append(s.Entries, ScheduleEntry{Kind: scheduleSend, From: "node2", To: "node1", Type: util.Proposal, Height: 2, Round: 0, Value: "CCCC"})
+	return s
+}
+
+func TestTLATraceMatchesGoldenFiles(t *testing.T) {
+	trace, err := NewTLATrace(tlaSchedule(), 4, []int{0}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var module, config bytes.Buffer
+	if err := trace.WriteModule(&module); err != nil {
+		t.Fatal(err)
+	}
+	if err := trace.WriteConfig(&config, len(trace.Steps)); err != nil {
+		t.Fatal(err)
+	}
+	for name, got := range map[string][]byte{TLATraceModule + ".tla": module.Bytes(), TLATraceModule + ".cfg": config.Bytes()} {
+		path := filepath.Join("testdata", name)
+		if *update {
+			if err := os.WriteFile(path, got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s differs from %s, rerun with -update if the change is intended:\n%s", name, path, got)
+		}
+	}
+}
+
+func TestTLATraceNeedsProposals(t *testing.T) {
+	s := NewSchedule()
+	s.Entries = append(s.Entries, ScheduleEntry{Kind: scheduleStep, From: "node1", Height: 1, Step: "Propose"})
+	if _, err := NewTLATrace(s, 4, nil, 1); err == nil {
+		t.Error("exported a height without proposals")
+	}
+}
@@ -0,0 +1,213 @@
+package byzzfuzz
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// traceEvent is an event of the Chrome trace event format, which Perfetto and chrome://tracing open
+type traceEvent struct {
+	Name string  `json:"name"`
+	Cat  string  `json:"cat,omitempty"`
+	Ph   string  `json:"ph"`
+	Ts   float64 `json:"ts"`
+	Dur  float64 `json:"dur,omitempty"`
+	Pid  int     `json:"pid"`
+	Tid  int     `json:"tid"`
+	// Flow events
+	ID int    `json:"id,omitempty"`
+	Bp string `json:"bp,omitempty"`
+	// Scope of instant events
+	S    string                 `json:"s,omitempty"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// Duration of the slices of sends and receives in microseconds, flow arrows need a slice to start and end in
+const traceMessageDur = 1
+
+// WriteChromeTrace converts a schedule to the Chrome trace event format, with a thread per replica.
+// Steps are slices that last until the next step of their replica, sends and receives are short slices within
+// them, joined by flow arrows. Drops, corruptions and commits are instant events.
+func WriteChromeTrace(s *Schedule, w io.Writer) error {
+	if len(s.Entries) == 0 {
+		return errors.New("empty schedule")
+	}
+	start := s.Entries[0].Time
+	if start == 0 {
+		return errors.New("the schedule has no times, it was recorded before schedules had them")
+	}
+	ts := func(entry ScheduleEntry) float64 {
+		return float64(entry.Time-start) / 1000
+	}
+
+	nodes := make([]string, 0)
+	tids := make(map[string]int)
+	delivered, received := make(map[string]bool), make(map[string]bool)
+	for _, entry := range s.Entries {
+		for _, node := range []string{entry.From, entry.To} {
+			if _, ok := tids[node]; node != "" && !ok {
+				tids[node] = 0
+				nodes = append(nodes, node)
+			}
+		}
+		if entry.Kind == scheduleDeliver {
+			delivered[entry.ID] = true
+		} else if entry.Kind == scheduleReceive {
+			received[entry.ID] = true
+		}
+	}
+	sortNodeLabels(nodes)
+	events := []traceEvent{{Name: "process_name", Ph: "M", Pid: 1, Args: map[string]interface{}{"name": "ByzzFuzz run"}}}
+	for i, node := range nodes {
+		tids[node] = i + 1
+		events = append(events,
+			traceEvent{Name: "thread_name", Ph: "M", Pid: 1, Tid: i + 1, Args: map[string]interface{}{"name": node}},
+			traceEvent{Name: "thread_sort_index", Ph: "M", Pid: 1, Tid: i + 1, Args: map[string]interface{}{"sort_index": i}})
+	}
+
+	end := ts(s.Entries[len(s.Entries)-1])
+	// Steps last until the next step of their replica, the last one until the end of the run
+	steps := make(map[string]*traceEvent)
+	endStep := func(node string, at float64) {
+		if step, ok := steps[node]; ok {
+			step.Dur = at - step.Ts
+			events = append(events, *step)
+			delete(steps, node)
+		}
+	}
+	// Flow IDs by the ID of the message they deliver
+	flows := make(map[string]int)
+	nextFlow := 1
+	var lastSend *ScheduleEntry
+	for i := range s.Entries {
+		entry := s.Entries[i]
+		args := map[string]interface{}{"height": entry.Height, "round": entry.Round}
+		switch entry.Kind {
+		case scheduleStep:
+			endStep(entry.From, ts(entry))
+			args["step"] = entry.Step
+			steps[entry.From] = &traceEvent{
+				Name: fmt.Sprintf("H%d R%d %s", entry.Height, entry.Round, entry.Step),
+				Cat:  "step",
+				Ph:   "X",
+				Ts:   ts(entry),
+				Pid:  1,
+				Tid:  tids[entry.From],
+				Args: args,
+			}
+		case scheduleCommit:
+			events = append(events, traceEvent{
+				Name: fmt.Sprintf("Commit H%d R%d", entry.Height, entry.Round),
+				Cat:  "commit",
+				Ph:   "i",
+				Ts:   ts(entry),
+				Pid:  1,
+				Tid:  tids[entry.From],
+				S:    "t",
+				Args: args,
+			})
+		case scheduleSend:
+			lastSend = &s.Entries[i]
+			args["to"], args["decision"] = entry.To, entry.Decision
+			events = append(events, traceEvent{
+				Name: fmt.Sprintf("Send %s to %s", entry.Type, entry.To),
+				Cat:  "message",
+				Ph:   "X",
+				Ts:   ts(entry),
+				Dur:  traceMessageDur,
+				Pid:  1,
+				Tid:  tids[entry.From],
+				Args: args,
+			})
+			switch {
+			case (entry.Decision == decisionDrop || entry.Decision == decisionHold) && !delivered[entry.ID]:
+				events = append(events, traceEvent{
+					Name: fmt.Sprintf("Drop %s to %s", entry.Type, entry.To),
+					Cat:  "drop",
+					Ph:   "i",
+					Ts:   ts(entry),
+					Pid:  1,
+					Tid:  tids[entry.From],
+					S:    "t",
+					Args: args,
+				})
+			case entry.Decision == decisionCorrupt:
+				events = append(events, traceEvent{
+					Name: fmt.Sprintf("Corrupt %s to %s", entry.Type, entry.To),
+					Cat:  "corruption",
+					Ph:   "i",
+					Ts:   ts(entry),
+					Pid:  1,
+					Tid:  tids[entry.From],
+					S:    "t",
+					Args: args,
+				})
+			case received[entry.ID]:
+				events = append(events, flowStart(entry, ts(entry), tids[entry.From], nextFlow))
+				flows[entry.ID] = nextFlow
+				nextFlow++
+			}
+		case scheduleDeliver:
+			// The filters deliver the corrupted messages of a send right after it. Their receives are not recorded,
+			// the testing server does not parse the messages that filters create.
+			if lastSend == nil || lastSend.Decision != decisionCorrupt || entry.ID == lastSend.ID ||
+				entry.From != lastSend.From || entry.To != lastSend.To || !received[entry.ID] {
+				continue
+			}
+			events = append(events, flowStart(*lastSend, ts(*lastSend), tids[lastSend.From], nextFlow))
+			flows[entry.ID] = nextFlow
+			nextFlow++
+		case scheduleReceive:
+			args["from"] = entry.From
+			events = append(events, traceEvent{
+				Name: fmt.Sprintf("Receive %s from %s", entry.Type, entry.From),
+				Cat:  "message",
+				Ph:   "X",
+				Ts:   ts(entry),
+				Dur:  traceMessageDur,
+				Pid:  1,
+				Tid:  tids[entry.To],
+				Args: args,
+			})
+			if flow, ok := flows[entry.ID]; ok {
+				events = append(events, traceEvent{
+					Name: string(entry.Type),
+					Cat:  "message",
+					Ph:   "f",
+					Ts:   ts(entry),
+					Pid:  1,
+					Tid:  tids[entry.To],
+					ID:   flow,
+					Bp:   "e",
+				})
+				// Nodes receive every message once
+				delete(flows, entry.ID)
+			}
+		}
+		if entry.Kind != scheduleSend && entry.Kind != scheduleDeliver {
+			lastSend = nil
+		}
+	}
+	for _, node := range nodes {
+		endStep(node, end)
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+}
+
+func flowStart(sent ScheduleEntry, ts float64, tid int, id int) traceEvent {
+	return traceEvent{
+		Name: string(sent.Type),
+		Cat:  "message",
+		Ph:   "s",
+		Ts:   ts,
+		Pid:  1,
+		Tid:  tid,
+		ID:   id,
+	}
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"bytes"
+	"byzzfuzz/artifacts"
 	"byzzfuzz/byzzfuzz"
 	"byzzfuzz/byzzfuzz/spec"
 	"byzzfuzz/docker"
+	"byzzfuzz/sim"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/netrixframework/netrix/config"
+	nlog "github.com/netrixframework/netrix/log"
 	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/netrix/types"
 	"github.com/netrixframework/tendermint-testing/common"
 	"github.com/netrixframework/tendermint-testing/util"
 
@@ -27,7 +38,9 @@ import (
 )
 
 var serverBindIp = flag.String("bind-ip", "192.167.0.1", "IP address to bind the testing server on. Should match controller-master-addr in node configuration.")
+var backend = flag.String("backend", "docker", "Nodes to test, one of docker|sim. The sim backend runs simulated nodes in-process, use it with --bind-ip 127.0.0.1")
 var logLevel = flag.String("log-level", "info", "Log level, one of panic|fatal|error|warn|warning|info|debug|trace")
+var runsDir = flag.String("runs-dir", "", "Directory for the artifacts of every run (default runs, or <logs-dir>/runs for campaigns)")
 
 const (
 	// Main parameters for ByzzFuzz algorithm
@@ -52,23 +65,163 @@ var verifyCmd = flag.NewFlagSet("verify", flag.ExitOnError)
 var runInstanceCmd = flag.NewFlagSet("run-instance", flag.ExitOnError)
 var livenessTimeout = runInstanceCmd.Duration("liveness-timeout", 1*time.Minute, "Time to wait for a new commit after the network heals, to verify liveness")
 
+var serveCmd = flag.NewFlagSet("serve", flag.ExitOnError)
+var serveAddr = serveCmd.String("addr", "127.0.0.1:8074", "Address to serve the HTTP API on")
+
+var analyzeCmd = flag.NewFlagSet("analyze", flag.ExitOnError)
+var analyzeDb = analyzeCmd.String("db", "test_results.sqlite3", "Path to the test results of the runs to check again")
+
+var replayCmd = flag.NewFlagSet("replay", flag.ExitOnError)
+var replayRunDir = replayCmd.String("run-dir", "", "Directory of the run to replay, with its config.json and schedule.jsonl")
+
+var diagramCmd = flag.NewFlagSet("diagram", flag.ExitOnError)
+var diagramRunDir = diagramCmd.String("run-dir", "", "Directory of the run to draw, its checker.log is drawn")
+var diagramLog = diagramCmd.String("log", "", "Checker log or event log to draw, instead of the log of --run-dir")
+var diagramOut = diagramCmd.String("out", "diagram.html", "Output file, an SVG image if it ends in .svg and an HTML page otherwise")
+var diagramAllCopies = diagramCmd.Bool("all-copies", false, "Draw every copy of messages that nodes send more than once")
+
+var traceCmd = flag.NewFlagSet("trace", flag.ExitOnError)
+var traceRunDir = traceCmd.String("run-dir", "", "Directory of the run to export, its schedule.jsonl is exported")
+var traceOut = traceCmd.String("out", "trace.json", "Output file")
+
+var checkTraceCmd = flag.NewFlagSet("check-trace", flag.ExitOnError)
+var checkTraceRunDir = checkTraceCmd.String("run-dir", "", "Directory of the run to check, its schedule.jsonl is checked")
+var checkTraceHeight = checkTraceCmd.Int("height", 1, "Height of the run to check")
+var checkTraceSpecDir = checkTraceCmd.String("spec-dir", ".", "Directory of "+byzzfuzz.TLASpecModule+".tla")
+var checkTraceTLC = checkTraceCmd.String("tlc-jar", "tla2tools.jar", "Path to the TLC jar, tla2tools.jar")
+var checkTraceApalache = checkTraceCmd.String("apalache-jar", "", "Path to the Apalache jar, checks with Apalache instead of TLC if set")
+
 var baselineCmd = flag.NewFlagSet("baseline", flag.ExitOnError)
 var dropPercent = baselineCmd.Int("drop-percent", 25, "Percentage of messages to drop (e.g. 25 for 25%)")
 var corruptPercent = baselineCmd.Int("corrupt-percent", 25, "Percentage of messages to corrupt (e.g. 25 for 25%)")
 
-var sysParams = common.NewSystemParams(4)
+var fuzzDeflakeCmd = flag.NewFlagSet("fuzz-deflake", flag.ExitOnError)
+var deflakeDrops = fuzzDeflakeCmd.Int("drops", 1, "Number of network link faults per instance")
+var deflakeCorruptions = fuzzDeflakeCmd.Int("corruptions", 0, "Number of message corruptions per instance")
+var deflakeMaxDrops = fuzzDeflakeCmd.Int("max-drops", -1, "If set, pick the number of network link faults uniformly from [0, max-drops]")
+var deflakeMaxCorruptions = fuzzDeflakeCmd.Int("max-corruptions", -1, "If set, pick the number of message corruptions uniformly from [0, max-corruptions]")
 
-func main() {
-	flag.Parse()
-	commandIndex := 1
-	for _, v := range os.Args[1:] {
-		if !strings.HasPrefix(v, "-") {
-			break
+var deflakeCmd = flag.NewFlagSet("deflake", flag.ExitOnError)
+
+var reproduceCmd = flag.NewFlagSet("reproduce", flag.ExitOnError)
+var reproduceMaxDrops = reproduceCmd.Int("max-drops", 2, "Generate instances with up to this many network link faults")
+var reproduceMaxCorruptions = reproduceCmd.Int("max-corruptions", 2, "Generate instances with up to this many message corruptions")
+var reproduceConfigs = reproduceCmd.Int("configs", 200, "Number of instances to generate for every combination of drops and corruptions")
+
+var minimizeCmd = flag.NewFlagSet("minimize", flag.ExitOnError)
+var minimizeRepetitions = minimizeCmd.Int("repetitions", deflakeRuns, "Number of runs a candidate must fail, without passing, to be considered failing")
+
+// Shared by fuzz-deflake, deflake, reproduce and minimize
+var campaignScope string
+var campaignLogsDir string
+var campaignLivenessTimeout time.Duration
+
+// Where runs are stored unless --runs-dir is set, campaigns keep their runs next to their results
+var defaultRunsDir = "runs"
+
+var seed int64
+
+// Whether --seed was given, 0 is a seed like any other
+var seedSet bool
+
+// Shared by fuzz and fuzz-deflake
+var nDelays int
+var nReorders int
+var sampleTimeouts bool
+var guided bool
+
+// Shared by fuzz and fuzz-deflake
+var nWorkers = 1
+
+var nodes int
+
+// Set from the --nodes flag once the subcommand arguments have been parsed
+var sysParams *common.SystemParams
+
+func init() {
+	for _, cmd := range []*flag.FlagSet{fuzzCmd, unittestCmd, verifyCmd, runInstanceCmd, serveCmd, analyzeCmd, replayCmd, checkTraceCmd, baselineCmd, fuzzDeflakeCmd, deflakeCmd, reproduceCmd, minimizeCmd} {
+		cmd.IntVar(&nodes, "nodes", 4, "Number of replicas in the cluster")
+	}
+	for _, cmd := range []*flag.FlagSet{fuzzDeflakeCmd, deflakeCmd, reproduceCmd} {
+		cmd.StringVar(&campaignScope, "scope", "", "Corruption scope, one of small|any|equivocation|gossip")
+		cmd.StringVar(&campaignLogsDir, "logs-dir", "", "Directory for the results database and event logs (default logs_<scope>_scope)")
+	}
+	for _, cmd := range []*flag.FlagSet{fuzzCmd, baselineCmd, fuzzDeflakeCmd, reproduceCmd} {
+		cmd.Int64Var(&seed, "seed", 0, "Seed for all random choices, a run with the same seed generates the same instances (default based on the current time)")
+	}
+	for _, cmd := range []*flag.FlagSet{fuzzCmd, fuzzDeflakeCmd} {
+		cmd.IntVar(&nDelays, "delays", 0, "Number of network link delays per instance")
+		cmd.IntVar(&nReorders, "reorders", 0, "Number of message reorderings per instance")
+		cmd.BoolVar(&sampleTimeouts, "sample-timeouts", false, "Give every node random consensus timeouts")
+		cmd.BoolVar(&guided, "guided", false, "Mutate instances that reached new protocol states, instead of only sampling instances at random")
+		cmd.IntVar(&nWorkers, "workers", 1, "Number of instances to run at once, every worker runs a cluster of its own")
+	}
+	serveCmd.IntVar(&nWorkers, "workers", 1, "Number of instances to run at once, every worker runs a cluster of its own")
+	replayCmd.DurationVar(livenessTimeout, "liveness-timeout", 1*time.Minute, "Time to wait for a new commit after the network heals, to verify liveness")
+	minimizeCmd.StringVar(&campaignLogsDir, "logs-dir", "logs_minimize", "Directory for the event logs of the candidate runs")
+	for _, cmd := range []*flag.FlagSet{fuzzDeflakeCmd, deflakeCmd, reproduceCmd, minimizeCmd, serveCmd} {
+		cmd.DurationVar(&campaignLivenessTimeout, "liveness-timeout", 1*time.Minute, "Time to wait for a new commit after the network heals, to verify liveness")
+	}
+}
+
+// newRand returns the random source of a campaign, seeded with the --seed flag
+func newRand() *rand.Rand {
+	if !seedSet {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("Using seed %d", seed)
+	return rand.New(rand.NewSource(seed))
+}
+
+// instanceSource draws the instances of a campaign from its random source one at a time, so that a seed generates
+// the same sequence of instances however many workers run them
+type instanceSource struct {
+	r      *rand.Rand
+	sample func(r *rand.Rand) byzzfuzz.ByzzFuzzInstanceConfig
+	mtx    sync.Mutex
+}
+
+func newInstanceSource(r *rand.Rand, sample func(r *rand.Rand) byzzfuzz.ByzzFuzzInstanceConfig) *instanceSource {
+	return &instanceSource{r: r, sample: sample}
+}
+
+// next draws the next instance of the campaign
+func (s *instanceSource) next() byzzfuzz.ByzzFuzzInstanceConfig {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.sample(s.r)
+}
+
+func parseArgs(cmd *flag.FlagSet, args []string) {
+	cmd.Parse(args)
+	cmd.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			seedSet = true
 		}
-		commandIndex++
+	})
+	if nodes < 4 {
+		log.Fatalf("need at least 4 nodes to tolerate a faulty node, got %d", nodes)
+	}
+	if nWorkers < 1 {
+		log.Fatalf("need at least one worker, got --workers %d", nWorkers)
 	}
+	// analyze and check-trace only read stored runs
+	offline := cmd == analyzeCmd || cmd == checkTraceCmd
+	if *backend == "docker" && nodes != docker.LocalnetNodes && !offline {
+		log.Fatalf("the docker local net has %d nodes, got --nodes %d, use --backend sim for other cluster sizes", docker.LocalnetNodes, nodes)
+	}
+	sysParams = common.NewSystemParams(nodes)
+}
+
+// Subcommands of the server, as listed in usage and error messages
+const subcommands = "unittest|fuzz|verify|run-instance|serve|analyze|replay|diagram|trace|check-trace|baseline|fuzz-deflake|deflake|reproduce|minimize"
+
+func main() {
+	flag.Parse()
+	// The subcommand follows the global flags, some of which take a separate value
+	commandIndex := len(os.Args) - flag.NArg()
 	if len(os.Args) <= commandIndex {
-		fmt.Printf("Usage: %s unittest|fuzz\n", os.Args[0])
+		fmt.Printf("Usage: %s %s\n", os.Args[0], subcommands)
 		os.Exit(1)
 	}
 	switch os.Args[commandIndex] {
@@ -80,24 +233,47 @@ func main() {
 		verify(os.Args[commandIndex+1:])
 	case "run-instance":
 		runInstance(os.Args[commandIndex+1:])
+	case "serve":
+		serve(os.Args[commandIndex+1:])
+	case "analyze":
+		analyze(os.Args[commandIndex+1:])
+	case "replay":
+		replay(os.Args[commandIndex+1:])
+	case "diagram":
+		diagram(os.Args[commandIndex+1:])
+	case "trace":
+		trace(os.Args[commandIndex+1:])
+	case "check-trace":
+		checkTrace(os.Args[commandIndex+1:])
 	case "baseline":
 		baseline(os.Args[commandIndex+1:])
+	case "fuzz-deflake":
+		fuzzDeflake(os.Args[commandIndex+1:])
+	case "deflake":
+		deflake(os.Args[commandIndex+1:])
+	case "reproduce":
+		reproduce(os.Args[commandIndex+1:])
+	case "minimize":
+		minimize(os.Args[commandIndex+1:])
 	default:
-		fmt.Println("expected 'unittest' or 'fuzz' subcommands")
+		fmt.Printf("unknown subcommand %q, expected one of %s\n", os.Args[commandIndex], subcommands)
 		os.Exit(1)
 	}
 }
 
 func runInstance(args []string) {
-	runInstanceCmd.Parse(args)
+	parseArgs(runInstanceCmd, args)
 
 	// Read testcase from stdin
-	instConf, err := byzzfuzz.InstanceFromJson(os.Stdin)
+	instConf, err := byzzfuzz.InstanceFromJson(os.Stdin, sysParams)
 	if err != nil {
 		log.Fatalf("failed to parse JSON definition for instance: %s", err.Error())
 	}
 	instConf.LivenessTimeout = *livenessTimeout
-	testcase, specCh := instConf.TestCase()
+	schedule := byzzfuzz.NewSchedule()
+	opts := instConf.Options()
+	opts.Nodes, opts.Record, opts.Wake = mainWorker.nodes, schedule, mainWorker.wake
+	testcase, specCh := byzzfuzz.ByzzFuzzInst(opts)
 
 	confB, err := json.Marshal(instConf)
 	if err != nil {
@@ -109,142 +285,1021 @@ func runInstance(args []string) {
 		log.Fatal(err)
 	}
 
-	runSingleTestCase(sysParams, testcase)
+	run := newRun(mainWorker)
+	writeRunConfig(run, instConf.Json())
+	terminate := runSingleTestCase(mainWorker, sysParams, testcase, instConf.Timeouts, run)
+	finishRun(run, testcase, specCh, terminate)
+	writeSchedule(run, schedule)
+}
+
+// serve runs the instances that clients submit over HTTP, in the order they were submitted
+func serve(args []string) {
+	parseArgs(serveCmd, args)
+	queue := newInstanceQueue()
+
+	httpServer := &http.Server{Addr: *serveAddr, Handler: queue.handler()}
+	go func() {
+		err := httpServer.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to serve API: %s", err.Error())
+		}
+	}()
+	log.Printf("Serving API on http://%s", *serveAddr)
+
+	// A run in progress is interrupted by runSingleTestCase, idle workers are stopped here
+	termCh := make(chan os.Signal, 1)
+	signal.Notify(termCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-termCh
+		queue.close()
+	}()
 
-	// TODO remove
-	// Drain the spec channel, not used
-	close(specCh)
-	for len(specCh) > 0 {
-		<-specCh
+	runWorkers(func(w *worker) {
+		for {
+			inst, ok := queue.next()
+			if !ok {
+				return
+			}
+			result, run, terminate := runCampaignInstance(w, inst.Config, nil)
+			queue.finish(inst, result, run, terminate)
+			if terminate {
+				queue.close()
+				return
+			}
+		}
+	})
+	httpServer.Close()
+}
+
+// analyze checks the stored runs of a test database again with the current oracles, without running any nodes,
+// and updates their verdicts
+func analyze(args []string) {
+	parseArgs(analyzeCmd, args)
+	db := openTestDb(*analyzeDb)
+	logger := nlog.NewLogger(config.LogConfig{})
+	logger.SetLevel(*logLevel)
+
+	type storedTest struct {
+		rowid  int64
+		config string
+		runDir sql.NullString
 	}
+	// Read all rows first, the database has a single connection
+	rows, err := db.Query("SELECT rowid, config, run_dir FROM TestResults")
+	if err != nil {
+		log.Fatalf("failed to read test results: %s", err.Error())
+	}
+	tests := make([]storedTest, 0)
+	for rows.Next() {
+		var t storedTest
+		err = rows.Scan(&t.rowid, &t.config, &t.runDir)
+		if err != nil {
+			log.Fatalf("failed to read test result: %s", err.Error())
+		}
+		tests = append(tests, t)
+	}
+	rows.Close()
+
+	analyzed, changed, withoutReplicaEvents := 0, 0, 0
+	for _, t := range tests {
+		if !t.runDir.Valid || t.runDir.String == "" {
+			log.Printf("Skipping test %d: no run directory", t.rowid)
+			continue
+		}
+		instance, err := byzzfuzz.InstanceFromJson(strings.NewReader(t.config), sysParams)
+		if err != nil {
+			log.Printf("Skipping test %d: invalid config: %s", t.rowid, err.Error())
+			continue
+		}
+		run := artifacts.OpenRun(t.runDir.String)
+		specEvents, err := readSpecLog(db, t.rowid, run)
+		if err != nil {
+			log.Printf("Skipping test %d: cannot read spec log: %s", t.rowid, err.Error())
+			continue
+		}
+		checkerLog, err := os.Open(run.CheckerLogPath())
+		if err != nil {
+			log.Printf("Skipping test %d: %s", t.rowid, err.Error())
+			continue
+		}
+		testcase, err := byzzfuzz.AnalyzeLog(checkerLog, instance, logger)
+		checkerLog.Close()
+		if errors.Is(err, byzzfuzz.ErrNoReplicaEvents) {
+			log.Printf("Skipping test %d: recorded before replica events were logged at info level", t.rowid)
+			withoutReplicaEvents++
+			continue
+		} else if err != nil {
+			log.Printf("Skipping test %d: cannot replay checker log: %s", t.rowid, err.Error())
+			continue
+		}
+
+		result := checkOracles(testcase)
+		result.specEvents = specEvents
+		result.specViolations = spec.Check(specEvents, sysParams.F)
+		result.spec = specHolds(testcase, result.specViolations)
+		analyzed++
+		if updateTestResult(db, t.rowid, result) {
+			changed++
+		}
+	}
+	log.Printf("Checked %d of %d tests again, the verdicts of %d changed", analyzed, len(tests), changed)
+	if withoutReplicaEvents > 0 {
+		log.Printf("Skipped %d tests that were recorded before replica events were logged at info level, "+
+			"their verdicts are unchecked: only runs with --log-level debug can be checked again", withoutReplicaEvents)
+	}
+}
+
+// readSpecLog returns the spec events of a test, from the database or else from its run directory
+func readSpecLog(db *sql.DB, rowid int64, run *artifacts.Run) ([]spec.Event, error) {
+	var specLog string
+	err := db.QueryRow("SELECT log FROM SpecLogs WHERE test_id = ?", rowid).Scan(&specLog)
+	if err == nil {
+		return spec.ReadLog(strings.NewReader(specLog))
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	f, err := os.Open(run.SpecLogPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return spec.ReadLog(f)
+}
+
+// updateTestResult replaces the verdicts and spec violations of a test, and returns whether any verdict changed
+func updateTestResult(db *sql.DB, rowid int64, result testResult) bool {
+	var agreement, specOk, liveness, validity, integrity sql.NullBool
+	err := db.QueryRow("SELECT agreement, spec, liveness, validity, integrity FROM TestResults WHERE rowid = ?", rowid).
+		Scan(&agreement, &specOk, &liveness, &validity, &integrity)
+	if err != nil {
+		log.Fatalf("failed to read test result: %s", err.Error())
+	}
+	changes := make([]string, 0)
+	for _, verdict := range []struct {
+		name   string
+		before sql.NullBool
+		after  bool
+	}{
+		{"agreement", agreement, result.agreement},
+		{"spec", specOk, result.spec},
+		{"liveness", liveness, result.liveness},
+		{"validity", validity, result.validity},
+		{"integrity", integrity, result.integrity},
+	} {
+		if !verdict.before.Valid || verdict.before.Bool != verdict.after {
+			changes = append(changes, fmt.Sprintf("%s %t", verdict.name, verdict.after))
+		}
+	}
+	if len(changes) > 0 {
+		log.Printf("Test %d: %s", rowid, strings.Join(changes, ", "))
+	}
+
+	_, err = db.Exec("UPDATE TestResults SET agreement = ?, spec = ?, liveness = ?, validity = ?, integrity = ? WHERE rowid = ?",
+		result.agreement, result.spec, result.liveness, result.validity, result.integrity, rowid)
+	if err != nil {
+		log.Fatalf("failed to write to DB: %s", err.Error())
+	}
+	_, err = db.Exec("DELETE FROM SpecViolations WHERE test_id = ?", rowid)
+	if err != nil {
+		log.Fatalf("failed to write spec violations to DB: %s", err.Error())
+	}
+	addSpecViolations(db, rowid, result.specViolations)
+	return len(changes) > 0
 }
 
 func baseline(args []string) {
-	baselineCmd.Parse(args)
+	parseArgs(baselineCmd, args)
 
-	testcase := byzzfuzz.BaselineTestCase(sysParams, *dropPercent, *corruptPercent)
+	testcase := byzzfuzz.BaselineTestCase(sysParams, newRand().Int63(), *dropPercent, *corruptPercent)
 
-	runSingleTestCase(sysParams, testcase)
+	run := newRun(mainWorker)
+	terminate := runSingleTestCase(mainWorker, sysParams, testcase, nil, run)
+	finishRun(run, testcase, nil, terminate)
 }
 
 func unittest(args []string) {
-	unittestCmd.Parse(args)
+	parseArgs(unittestCmd, args)
+	run := newRun(mainWorker)
 	if *useByzzfuzz {
 		testcase, specCh := byzzfuzz.ByzzFuzzExpectNewRound(sysParams)
-		runSingleTestCase(sysParams, testcase)
-		agreementOk := !(testcase.StateMachine.CurState().Label == byzzfuzz.DiffCommitsLabel)
-		if agreementOk {
-			log.Println("Agreement OK")
-		} else {
-			log.Println("Agreement FAIL")
-		}
-		livenessOk := testcase.StateMachine.InSuccessState()
-		if livenessOk {
-			log.Println("Liveness OK")
-		} else {
-			log.Println("Liveness FAIL")
-		}
-		if spec.Check(specCh) {
-			log.Println("Spec OK")
-		} else {
-			log.Println("Spec FAIL")
-		}
+		terminate := runSingleTestCase(mainWorker, sysParams, testcase, nil, run)
+		finishRun(run, testcase, specCh, terminate)
 	} else {
-		runSingleTestCase(sysParams, byzzfuzz.ExpectNewRound(sysParams))
+		testcase := byzzfuzz.ExpectNewRound(sysParams)
+		terminate := runSingleTestCase(mainWorker, sysParams, testcase, nil, run)
+		finishRun(run, testcase, nil, terminate)
 	}
 }
 
 type testResult struct {
-	agreement bool
-	spec      bool
-	liveness  bool
+	agreement      bool
+	validity       bool
+	integrity      bool
+	spec           bool
+	liveness       bool
+	specEvents     []spec.Event
+	specViolations []spec.Violation
+	// States and transitions that no earlier run of the campaign covered
+	newCoverage int
+}
+
+// A guided campaign samples a fresh instance instead of mutating the corpus once in this many iterations
+const guidedExploreOneIn = 4
+
+// guidedCorpus is the corpus of a campaign, which all its workers share
+type guidedCorpus struct {
+	corpus *byzzfuzz.Corpus
+	mtx    sync.Mutex
+}
+
+func newGuidedCorpus() *guidedCorpus {
+	return &guidedCorpus{corpus: byzzfuzz.NewCorpus()}
+}
+
+// next returns the instance to run: with --guided mostly a mutation of the corpus, otherwise a sampled instance
+func (g *guidedCorpus) next(r *rand.Rand, scope byzzfuzz.Scope, steps int, sample func() byzzfuzz.ByzzFuzzInstanceConfig) byzzfuzz.ByzzFuzzInstanceConfig {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	// Keep sampling fresh instances now and then, so that the corpus does not get stuck
+	if guided && len(g.corpus.Instances) > 0 && r.Intn(guidedExploreOneIn) != 0 {
+		return g.corpus.Mutate(r, scope, steps)
+	}
+	return sample()
+}
+
+// add writes the coverage of a run of the instance, and adds the instance to the corpus if the run covered
+// anything new. It returns how many states and transitions were new.
+func (g *guidedCorpus) add(run *artifacts.Run, instance byzzfuzz.ByzzFuzzInstanceConfig, cov *byzzfuzz.Coverage) int {
+	err := run.WriteCoverage(cov.Keys())
+	if err != nil {
+		log.Fatalf("failed to write coverage: %s", err.Error())
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	added := g.corpus.Add(instance, cov)
+	log.Printf("Covered %d new states and transitions, %d in total, corpus of %d instances",
+		added, g.corpus.Coverage.Size(), len(g.corpus.Instances))
+	return added
 }
 
 func fuzz(args []string) {
-	fuzzCmd.Parse(args)
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	db := openTestDb()
+	parseArgs(fuzzCmd, args)
+	r := newRand()
+	db := openTestDb(*testDb)
 	_ = db
 
-	for i := 0; i < *iterations; i++ {
-		instance := byzzfuzz.ByzzFuzzRandom(sysParams, r, *drops, *corruptions, *steps, *timeout)
-		log.Printf("Running test instance: %s", instance.Json())
-		testcase, specCh := instance.TestCase()
-		if runSingleTestCase(sysParams, testcase) {
-			break
-		}
-		agreementOk := !(testcase.StateMachine.CurState().Label == byzzfuzz.DiffCommitsLabel)
-		if agreementOk {
-			log.Println("Agreement OK")
-		} else {
-			log.Println("Agreement FAIL")
-		}
-		livenessOk := testcase.StateMachine.InSuccessState()
-		if livenessOk {
-			log.Println("Liveness OK")
-		} else {
-			log.Println("Liveness FAIL")
+	corpus := newGuidedCorpus()
+	instances := newInstanceSource(r, func(r *rand.Rand) byzzfuzz.ByzzFuzzInstanceConfig {
+		return corpus.next(r, byzzfuzz.SmallScope, *steps, func() byzzfuzz.ByzzFuzzInstanceConfig {
+			return byzzfuzz.ByzzFuzzRandom(sysParams, r, byzzfuzz.SmallScope, *drops, *corruptions, nDelays, nReorders, *steps, *timeout, sampleTimeouts)
+		})
+	})
+	// Guards the iteration count, which all workers share
+	var mtx sync.Mutex
+	started, terminated := 0, false
+	runWorkers(func(w *worker) {
+		for {
+			mtx.Lock()
+			if terminated || started >= *iterations {
+				mtx.Unlock()
+				return
+			}
+			started++
+			mtx.Unlock()
+			instance := instances.next()
+			if err := instance.Validate(); err != nil {
+				log.Fatalf("generated an invalid instance: %s", err.Error())
+			}
+			log.Printf("Running test instance: %s", instance.Json())
+			cov := byzzfuzz.NewCoverage()
+			schedule := byzzfuzz.NewSchedule()
+			opts := instance.Options()
+			opts.Nodes, opts.Coverage, opts.Record, opts.Wake = w.nodes, cov, schedule, w.wake
+			testcase, specCh := byzzfuzz.ByzzFuzzInst(opts)
+			run := newRun(w)
+			writeRunConfig(run, instance.Json())
+			terminate := runSingleTestCase(w, sysParams, testcase, instance.Timeouts, run)
+			result := finishRun(run, testcase, specCh, terminate)
+			writeSchedule(run, schedule)
+			if terminate {
+				mtx.Lock()
+				terminated = true
+				mtx.Unlock()
+				return
+			}
+			result.newCoverage = corpus.add(run, instance, cov)
+			addTestResult(db, instance, result, run)
 		}
-		specOk := spec.Check(specCh)
-		if specOk {
-			log.Println("Spec OK")
-		} else {
-			log.Println("SPEC FAIL")
+	})
+}
+
+func verify(args []string) {
+	parseArgs(verifyCmd, args)
+	inst := byzzfuzz.Lagging(sysParams)
+
+	schedule := byzzfuzz.NewSchedule()
+	opts := inst.Options()
+	opts.Nodes, opts.Record, opts.Wake = mainWorker.nodes, schedule, mainWorker.wake
+	testcase, specCh := byzzfuzz.ByzzFuzzInst(opts)
+	run := newRun(mainWorker)
+	writeRunConfig(run, inst.Json())
+	terminate := runSingleTestCase(mainWorker, sysParams, testcase, inst.Timeouts, run)
+	finishRun(run, testcase, specCh, terminate)
+	writeSchedule(run, schedule)
+}
+
+// replay runs a stored run again, and delivers the consensus messages in the order of its schedule
+func replay(args []string) {
+	parseArgs(replayCmd, args)
+	if *replayRunDir == "" {
+		log.Fatal("--run-dir is required")
+	}
+	recorded := artifacts.OpenRun(*replayRunDir)
+
+	confFile, err := os.Open(recorded.ConfigPath())
+	if err != nil {
+		log.Fatalf("failed to open config of run %s: %s", recorded.ID, err.Error())
+	}
+	instConf, err := byzzfuzz.InstanceFromJson(confFile, sysParams)
+	confFile.Close()
+	if err != nil {
+		log.Fatalf("failed to parse config of run %s: %s", recorded.ID, err.Error())
+	}
+	scheduleFile, err := os.Open(recorded.SchedulePath())
+	if err != nil {
+		log.Fatalf("failed to open schedule of run %s: %s", recorded.ID, err.Error())
+	}
+	replayed, err := byzzfuzz.ReadSchedule(scheduleFile)
+	scheduleFile.Close()
+	if err != nil {
+		log.Fatalf("failed to parse schedule of run %s: %s", recorded.ID, err.Error())
+	}
+
+	instConf.LivenessTimeout = *livenessTimeout
+	log.Printf("Replaying run %s: %s", recorded.ID, instConf.Json())
+	schedule := byzzfuzz.NewSchedule()
+	opts := instConf.Options()
+	opts.Nodes, opts.Record, opts.Replay = mainWorker.nodes, schedule, replayed
+	opts.Wake = mainWorker.wake
+	testcase, specCh := byzzfuzz.ByzzFuzzInst(opts)
+	run := newRun(mainWorker)
+	writeRunConfig(run, instConf.Json())
+	terminate := runSingleTestCase(mainWorker, sysParams, testcase, instConf.Timeouts, run)
+	finishRun(run, testcase, specCh, terminate)
+	writeSchedule(run, schedule)
+}
+
+// diagram draws the log of a run as a message sequence chart
+func diagram(args []string) {
+	diagramCmd.Parse(args)
+	path := *diagramLog
+	if path == "" {
+		if *diagramRunDir == "" {
+			log.Fatal("either --run-dir or --log is required")
 		}
-		addTestResult(db, instance, testResult{agreement: agreementOk, spec: specOk, liveness: livenessOk})
+		path = artifacts.OpenRun(*diagramRunDir).CheckerLogPath()
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("failed to open log: %s", err.Error())
+	}
+	defer in.Close()
+	out, err := os.Create(*diagramOut)
+	if err != nil {
+		log.Fatalf("failed to create diagram: %s", err.Error())
+	}
+	defer out.Close()
+
+	err = byzzfuzz.RenderChart(in, out, byzzfuzz.ChartOptions{
+		HTML:      !strings.HasSuffix(*diagramOut, ".svg"),
+		AllCopies: *diagramAllCopies,
+	})
+	if err != nil {
+		log.Fatalf("failed to draw diagram: %s", err.Error())
 	}
+	log.Printf("Wrote %s", *diagramOut)
 }
 
-func verify(args []string) {
-	verifyCmd.Parse(args)
-	inst := byzzfuzz.Lagging()
+// trace exports the schedule of a run in the Chrome trace event format
+func trace(args []string) {
+	traceCmd.Parse(args)
+	if *traceRunDir == "" {
+		log.Fatal("--run-dir is required")
+	}
+	run := artifacts.OpenRun(*traceRunDir)
+	in, err := os.Open(run.SchedulePath())
+	if err != nil {
+		log.Fatalf("failed to open schedule of run %s: %s", run.ID, err.Error())
+	}
+	defer in.Close()
+	schedule, err := byzzfuzz.ReadSchedule(in)
+	if err != nil {
+		log.Fatalf("failed to parse schedule of run %s: %s", run.ID, err.Error())
+	}
+	out, err := os.Create(*traceOut)
+	if err != nil {
+		log.Fatalf("failed to create trace: %s", err.Error())
+	}
+	defer out.Close()
+	err = byzzfuzz.WriteChromeTrace(schedule, out)
+	if err != nil {
+		log.Fatalf("failed to export trace: %s", err.Error())
+	}
+	log.Printf("Wrote %s", *traceOut)
+}
+
+// checkTrace exports a height of a run as a trace of the Tendermint TLA+ spec, and checks it with TLC or Apalache
+// if Java and the jar are available
+func checkTrace(args []string) {
+	parseArgs(checkTraceCmd, args)
+	if *checkTraceRunDir == "" {
+		log.Fatal("--run-dir is required")
+	}
+	run := artifacts.OpenRun(*checkTraceRunDir)
+	confFile, err := os.Open(run.ConfigPath())
+	if err != nil {
+		log.Fatalf("failed to open config of run %s: %s", run.ID, err.Error())
+	}
+	instConf, err := byzzfuzz.InstanceFromJson(confFile, sysParams)
+	confFile.Close()
+	if err != nil {
+		log.Fatalf("failed to parse config of run %s: %s", run.ID, err.Error())
+	}
+	in, err := os.Open(run.SchedulePath())
+	if err != nil {
+		log.Fatalf("failed to open schedule of run %s: %s", run.ID, err.Error())
+	}
+	schedule, err := byzzfuzz.ReadSchedule(in)
+	in.Close()
+	if err != nil {
+		log.Fatalf("failed to parse schedule of run %s: %s", run.ID, err.Error())
+	}
+	tlaTrace, err := byzzfuzz.NewTLATrace(schedule, sysParams.N, instConf.Faulty, *checkTraceHeight)
+	if err != nil {
+		log.Fatalf("failed to export trace of run %s: %s", run.ID, err.Error())
+	}
+	writeTLAFile(run.TLATracePath(), tlaTrace.WriteModule)
+	writeTLAFile(run.TLAConfigPath(), func(w io.Writer) error {
+		return tlaTrace.WriteConfig(w, len(tlaTrace.Steps))
+	})
+	log.Printf("Wrote %s and %s, a trace of %d steps", run.TLATracePath(), run.TLAConfigPath(), len(tlaTrace.Steps))
+
+	checker := byzzfuzz.TLAChecker{Jar: *checkTraceTLC, SpecDir: *checkTraceSpecDir}
+	if *checkTraceApalache != "" {
+		checker.Jar, checker.Apalache = *checkTraceApalache, true
+	}
+	checker.Java, err = exec.LookPath("java")
+	if err != nil {
+		log.Printf("Java not found, the trace was not checked")
+		return
+	}
+	if _, err := os.Stat(checker.Jar); err != nil {
+		log.Printf("%s not found, the trace was not checked", checker.Jar)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(checker.SpecDir, byzzfuzz.TLASpecModule+".tla")); err != nil {
+		log.Printf("%s.tla not found in %s, the trace was not checked", byzzfuzz.TLASpecModule, checker.SpecDir)
+		return
+	}
+	checker.Jar, err = filepath.Abs(checker.Jar)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dir, err := os.MkdirTemp("", "check-trace")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	result, err := checker.Check(tlaTrace, dir)
+	if err != nil {
+		log.Fatalf("failed to check trace: %s", err.Error())
+	}
+	switch {
+	case result.Conforming == len(tlaTrace.Steps):
+		log.Printf("The trace conforms to %s", byzzfuzz.TLASpecModule)
+	case result.Conforming < 0:
+		log.Printf("The initial state does not conform to %s, the faulty nodes sent messages the spec does not allow", byzzfuzz.TLASpecModule)
+		os.Exit(1)
+	default:
+		log.Printf("The first %d steps conform to %s, step %d does not: %s", result.Conforming, byzzfuzz.TLASpecModule,
+			result.Conforming+1, result.Failed.Description)
+		os.Exit(1)
+	}
+}
+
+func writeTLAFile(path string, write func(w io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("failed to write %s: %s", path, err.Error())
+	}
+	defer f.Close()
+	err = write(f)
+	if err != nil {
+		log.Fatalf("failed to write %s: %s", path, err.Error())
+	}
+}
+
+// newRun creates the artifact directory of the next run. With several workers, every worker has a directory of its own.
+func newRun(w *worker) *artifacts.Run {
+	dir := *runsDir
+	if dir == "" {
+		dir = defaultRunsDir
+	}
+	if nWorkers > 1 {
+		dir = filepath.Join(dir, fmt.Sprintf("worker%d", w.index))
+	}
+	run, err := artifacts.NewRun(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Run %s, artifacts in %s", run.ID, run.Dir)
+	return run
+}
+
+func writeRunConfig(run *artifacts.Run, config string) {
+	err := run.WriteConfig(config)
+	if err != nil {
+		log.Fatalf("failed to write config: %s", err.Error())
+	}
+}
 
-	testcase, specCh := inst.TestCase()
-	runSingleTestCase(sysParams, testcase)
-	agreementOk := !(testcase.StateMachine.CurState().Label == byzzfuzz.DiffCommitsLabel)
-	if agreementOk {
+func writeSchedule(run *artifacts.Run, schedule *byzzfuzz.Schedule) {
+	f, err := os.Create(run.SchedulePath())
+	if err != nil {
+		log.Fatalf("failed to write schedule: %s", err.Error())
+	}
+	defer f.Close()
+	err = schedule.Write(f)
+	if err != nil {
+		log.Fatalf("failed to write schedule: %s", err.Error())
+	}
+}
+
+// finishRun checks the outcome of a run, and stores its spec events and a summary in the run directory.
+// specCh is nil for test cases that do not log spec events.
+func finishRun(run *artifacts.Run, testcase *testlib.TestCase, specCh chan spec.Event, terminated bool) testResult {
+	result := checkOracles(testcase)
+	if result.agreement {
 		log.Println("Agreement OK")
 	} else {
 		log.Println("Agreement FAIL")
 	}
-	livenessOk := testcase.StateMachine.InSuccessState()
-	if livenessOk {
+	if result.validity {
+		log.Println("Validity OK")
+	} else {
+		log.Println("Validity FAIL")
+	}
+	if result.integrity {
+		log.Println("Integrity OK")
+	} else {
+		log.Println("Integrity FAIL")
+	}
+	if result.liveness {
 		log.Println("Liveness OK")
 	} else {
 		log.Println("Liveness FAIL")
 	}
-	if spec.Check(specCh) {
+	if specCh != nil {
+		result.specEvents, result.specViolations = checkSpec(specCh)
+		result.spec = specHolds(testcase, result.specViolations)
+		err := run.WriteSpecLog(result.specEvents)
+		if err != nil {
+			log.Fatalf("failed to write spec log: %s", err.Error())
+		}
+	}
+
+	err := run.WriteSummary(artifacts.Summary{
+		Passed:         result.liveness,
+		Agreement:      result.agreement,
+		Validity:       result.validity,
+		Integrity:      result.integrity,
+		Liveness:       result.liveness,
+		Spec:           result.spec,
+		Terminated:     terminated,
+		SpecViolations: result.specViolations,
+	})
+	if err != nil {
+		log.Fatalf("failed to write run summary: %s", err.Error())
+	}
+	return result
+}
+
+// checkOracles reads the outcome of the oracles from the state the test case ended in
+func checkOracles(testcase *testlib.TestCase) testResult {
+	return testResult{
+		agreement: !(testcase.StateMachine.CurState().Label == byzzfuzz.DiffCommitsLabel),
+		validity:  !(testcase.StateMachine.CurState().Label == byzzfuzz.InvalidCommitLabel),
+		integrity: !(testcase.StateMachine.CurState().Label == byzzfuzz.DoubleCommitLabel),
+		liveness:  testcase.StateMachine.InSuccessState(),
+		spec:      true,
+	}
+}
+
+// specHolds is true if neither the spec check after the run nor the round skip monitor during the run found a violation
+func specHolds(testcase *testlib.TestCase, violations []spec.Violation) bool {
+	return len(violations) == 0 && testcase.StateMachine.CurState().Label != byzzfuzz.RoundSkipViolationLabel
+}
+
+// checkSpec collects the spec events of a finished run and checks them against the spec
+func checkSpec(specCh chan spec.Event) ([]spec.Event, []spec.Violation) {
+	events := spec.Collect(specCh)
+	violations := spec.Check(events, sysParams.F)
+	if len(violations) == 0 {
 		log.Println("Spec OK")
 	} else {
+		for _, v := range violations {
+			log.Printf("Spec violation: %s", v.String())
+		}
 		log.Println("Spec FAIL")
 	}
+	return events, violations
 }
 
-func openTestDb() *sql.DB {
-	db, err := sql.Open("sqlite", *testDb)
+// A config that fails this many times without ever passing is considered to fail reliably
+const deflakeRuns = 5
+
+func fuzzDeflake(args []string) {
+	parseArgs(fuzzDeflakeCmd, args)
+	scope := parseCampaignScope()
+	r := newRand()
+	db := openCampaignDb()
+
+	corpus := newGuidedCorpus()
+	instances := newInstanceSource(r, func(r *rand.Rand) byzzfuzz.ByzzFuzzInstanceConfig {
+		return corpus.next(r, scope, defaultMaxSteps, func() byzzfuzz.ByzzFuzzInstanceConfig {
+			return sampleDeflakeInstance(r, scope)
+		})
+	})
+	runWorkers(func(w *worker) {
+		for {
+			log.Println("=== FUZZ ===")
+			if fuzzOne(w, db, instances, corpus) {
+				return
+			}
+			log.Println("=== DEFLAKE ===")
+			if _, terminate := deflakeOne(w, db); terminate {
+				return
+			}
+		}
+	})
+}
+
+func deflake(args []string) {
+	parseArgs(deflakeCmd, args)
+	parseCampaignScope()
+	db := openCampaignDb()
+
+	for {
+		found, terminate := deflakeOne(mainWorker, db)
+		if terminate {
+			return
+		}
+		if !found {
+			log.Println("WARN: Nothing to deflake")
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func reproduce(args []string) {
+	parseArgs(reproduceCmd, args)
+	scope := parseCampaignScope()
+	r := newRand()
+	db := openCampaignDb()
+
+	expected := ((*reproduceMaxDrops+1)*(*reproduceMaxCorruptions+1) - 1) * *reproduceConfigs
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM TestResults").Scan(&count)
+	if err != nil {
+		log.Fatalf("failed to count configs: %s", err.Error())
+	}
+	// Check that we have the expected number of configs already, or nothing
+	if count == 0 {
+		for d := 0; d <= *reproduceMaxDrops; d++ {
+			for c := 0; c <= *reproduceMaxCorruptions; c++ {
+				if d == 0 && c == 0 {
+					continue
+				}
+				for i := 0; i < *reproduceConfigs; i++ {
+					instance := byzzfuzz.ByzzFuzzRandom(sysParams, r, scope, d, c, 0, 0, defaultMaxSteps, time.Minute, false)
+					insertCampaignResult(db, instance, 0, 0, true, "")
+				}
+			}
+		}
+	} else if count == expected {
+		log.Println("Already have all configs in results table, skipping generation")
+	} else {
+		log.Fatalf("results table is partially populated: %d of %d configs", count, expected)
+	}
+
+	for {
+		found, terminate := deflakeOne(mainWorker, db)
+		if terminate {
+			return
+		}
+		if !found {
+			break
+		}
+	}
+	log.Println("Done!")
+}
+
+// minimize reads a failing instance from stdin, and writes the smallest instance that still fails reliably to stdout
+func minimize(args []string) {
+	parseArgs(minimizeCmd, args)
+	defaultRunsDir = filepath.Join(campaignLogsDir, "runs")
+	err := os.MkdirAll(campaignLogsDir, 0755)
+	if err != nil {
+		log.Fatalf("failed to create logs directory: %s", err.Error())
+	}
+
+	instance, err := byzzfuzz.InstanceFromJson(os.Stdin, sysParams)
+	if err != nil {
+		log.Fatalf("failed to parse JSON definition for instance: %s", err.Error())
+	}
+
+	failsReliably := func(candidate byzzfuzz.ByzzFuzzInstanceConfig) bool {
+		for i := 0; i < *minimizeRepetitions; i++ {
+			result, _, terminate := runCampaignInstance(mainWorker, candidate, nil)
+			if terminate {
+				os.Exit(1)
+			}
+			if result.liveness {
+				return false
+			}
+		}
+		return true
+	}
+	if !failsReliably(instance) {
+		log.Fatalf("instance does not fail reliably, nothing to minimize")
+	}
+
+	minimized := byzzfuzz.Minimize(instance, failsReliably)
+	log.Printf("Minimized instance: %s", minimized.Json())
+	fmt.Println(minimized.Json())
+}
+
+// sampleDeflakeInstance samples a random instance of a fuzz-deflake campaign
+func sampleDeflakeInstance(r *rand.Rand, scope byzzfuzz.Scope) byzzfuzz.ByzzFuzzInstanceConfig {
+	nDrops := *deflakeDrops
+	if *deflakeMaxDrops >= 0 {
+		nDrops = r.Intn(*deflakeMaxDrops + 1)
+	}
+	nCorruptions := *deflakeCorruptions
+	if *deflakeMaxCorruptions >= 0 {
+		// Do not allow 0 drops and 0 corruptions
+		minCorruptions := 0
+		if nDrops == 0 && *deflakeMaxCorruptions > 0 {
+			minCorruptions = 1
+		}
+		nCorruptions = minCorruptions + r.Intn(*deflakeMaxCorruptions-minCorruptions+1)
+	}
+	log.Printf("drops: %d, corruptions: %d, delays: %d, reorders: %d", nDrops, nCorruptions, nDelays, nReorders)
+	return byzzfuzz.ByzzFuzzRandom(sysParams, r, scope, nDrops, nCorruptions, nDelays, nReorders, defaultMaxSteps, time.Minute, sampleTimeouts)
+}
+
+// fuzzOne runs a single random or, with --guided, mutated instance and records it as a new row in the results table.
+func fuzzOne(w *worker, db *sql.DB, instances *instanceSource, corpus *guidedCorpus) (terminate bool) {
+	instance := instances.next()
+	cov := byzzfuzz.NewCoverage()
+	result, run, terminate := runCampaignInstance(w, instance, cov)
+	if terminate {
+		return
+	}
+	corpus.add(run, instance, cov)
+
+	pass, fail := 0, 1
+	if result.liveness {
+		pass, fail = 1, 0
+	}
+	rowid := insertCampaignResult(db, instance, pass, fail, result.validity, run.Dir)
+	saveEventLog(run, fmt.Sprintf("events%06d.log", rowid))
+	return
+}
+
+// claimedRows are the configs that workers are deflaking, so that no two workers rerun the same config at once
+type claimedRows struct {
+	rows map[int64]bool
+	mtx  sync.Mutex
+}
+
+var deflaking = claimedRows{rows: make(map[int64]bool)}
+
+func (c *claimedRows) release(rowid int64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.rows, rowid)
+}
+
+// claimConfigToDeflake selects a random config that has failed but never passed, and that no other worker deflakes
+func claimConfigToDeflake(db *sql.DB) (rowid int64, jsonConfig string, found bool) {
+	deflaking.mtx.Lock()
+	defer deflaking.mtx.Unlock()
+	rows, err := db.Query("SELECT rowid, config FROM TestResults WHERE pass = 0 AND fail < ? ORDER BY RANDOM() LIMIT ?", deflakeRuns, nWorkers)
+	if err != nil {
+		log.Fatalf("failed to select config to deflake: %s", err.Error())
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&rowid, &jsonConfig)
+		if err != nil {
+			log.Fatalf("failed to select config to deflake: %s", err.Error())
+		}
+		if !deflaking.rows[rowid] {
+			deflaking.rows[rowid] = true
+			return rowid, jsonConfig, true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Fatalf("failed to select config to deflake: %s", err.Error())
+	}
+	return 0, "", false
+}
+
+// deflakeOne reruns a config that has failed but never passed, and updates its pass/fail counts.
+func deflakeOne(w *worker, db *sql.DB) (found bool, terminate bool) {
+	rowid, jsonConfig, found := claimConfigToDeflake(db)
+	if !found {
+		return
+	}
+	defer deflaking.release(rowid)
+
+	instance, err := byzzfuzz.InstanceFromJson(strings.NewReader(jsonConfig), sysParams)
+	if err != nil {
+		log.Fatalf("failed to parse stored config %d: %s", rowid, err.Error())
+	}
+	result, run, terminate := runCampaignInstance(w, instance, nil)
+	if terminate {
+		return
+	}
+
+	if result.liveness {
+		_, err = db.Exec("UPDATE TestResults SET pass = pass + 1, run_dir = ? WHERE rowid = ?", run.Dir, rowid)
+		saveEventLog(run, fmt.Sprintf("events%06d_pass.log", rowid))
+	} else {
+		_, err = db.Exec("UPDATE TestResults SET fail = fail + 1, validity = validity AND ?, run_dir = ? WHERE rowid = ?", result.validity, run.Dir, rowid)
+		saveEventLog(run, fmt.Sprintf("events%06d_fail.log", rowid))
+	}
+	if err != nil {
+		log.Fatalf("failed to write to DB: %s", err.Error())
+	}
+	return
+}
+
+// runCampaignInstance runs an instance, it passed if result.liveness holds. The coverage of the run is added to cov, if set.
+func runCampaignInstance(w *worker, instance byzzfuzz.ByzzFuzzInstanceConfig, cov *byzzfuzz.Coverage) (result testResult, run *artifacts.Run, terminate bool) {
+	instance.LivenessTimeout = campaignLivenessTimeout
+	log.Printf("Running test instance: %s", instance.Json())
+	schedule := byzzfuzz.NewSchedule()
+	opts := instance.Options()
+	opts.Nodes, opts.Coverage, opts.Record, opts.Wake = w.nodes, cov, schedule, w.wake
+	testcase, specCh := byzzfuzz.ByzzFuzzInst(opts)
+
+	run = newRun(w)
+	writeRunConfig(run, instance.Json())
+	terminate = runSingleTestCase(w, sysParams, testcase, instance.Timeouts, run)
+	result = finishRun(run, testcase, specCh, terminate)
+	writeSchedule(run, schedule)
+
+	if result.liveness {
+		log.Println("Testcase succeeded")
+	} else {
+		log.Println("Testcase failed")
+	}
+	return
+}
+
+// saveEventLog stores the config and checker log of a run under the given name in the logs directory
+func saveEventLog(run *artifacts.Run, name string) {
+	confB, err := os.ReadFile(run.ConfigPath())
+	if err != nil {
+		log.Fatalf("failed to read config of run %s: %s", run.ID, err.Error())
+	}
+	logB, err := os.ReadFile(run.CheckerLogPath())
+	if err != nil {
+		log.Fatalf("failed to read checker log of run %s: %s", run.ID, err.Error())
+	}
+	err = os.WriteFile(filepath.Join(campaignLogsDir, name), append(confB, logB...), 0644)
+	if err != nil {
+		log.Fatalf("failed to write event log: %s", err.Error())
+	}
+}
+
+func parseCampaignScope() byzzfuzz.Scope {
+	scope, err := byzzfuzz.ParseScope(campaignScope)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if campaignLogsDir == "" {
+		campaignLogsDir = fmt.Sprintf("logs_%s_scope", scope)
+	}
+	defaultRunsDir = filepath.Join(campaignLogsDir, "runs")
+	return scope
+}
+
+// openCampaignDb opens the results database of fuzz-deflake campaigns,
+// which only keeps pass/fail counts per config, and whether any run violated validity.
+func openCampaignDb() *sql.DB {
+	err := os.MkdirAll(campaignLogsDir, 0755)
+	if err != nil {
+		log.Fatalf("failed to create logs directory: %s", err.Error())
+	}
+	db, err := sql.Open("sqlite", filepath.Join(campaignLogsDir, "test_results.sqlite3"))
+	if err != nil {
+		log.Fatalf("failed to open test database: %s", err.Error())
+	}
+	// Workers share the database, a single connection serializes their writes
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS TestResults(
+			config JSON,
+			pass INT,
+			fail INT,
+			validity BOOL DEFAULT TRUE,
+			run_dir TEXT);
+	`)
+	if err != nil {
+		log.Fatalf("failed to create test database: %s", err.Error())
+	}
+	addColumn(db, "validity", "BOOL DEFAULT TRUE")
+	addColumn(db, "run_dir", "TEXT")
+
+	return db
+}
+
+// addColumn adds a column to the TestResults table of databases created before it existed
+func addColumn(db *sql.DB, column string, definition string) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('TestResults') WHERE name = ?", column).Scan(&count)
+	if err != nil {
+		log.Fatalf("failed to read test database schema: %s", err.Error())
+	}
+	if count > 0 {
+		return
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE TestResults ADD COLUMN %s %s", column, definition))
+	if err != nil {
+		log.Fatalf("failed to add %s column: %s", column, err.Error())
+	}
+}
+
+// insertCampaignResult adds a config, runDir is the directory of its latest run, or empty if it has not run yet
+func insertCampaignResult(db *sql.DB, instance byzzfuzz.ByzzFuzzInstanceConfig, pass int, fail int, validity bool, runDir string) int64 {
+	res, err := db.Exec("INSERT INTO TestResults(config, pass, fail, validity, run_dir) VALUES (?, ?, ?, ?, ?)", instance.Json(), pass, fail, validity, runDir)
+	if err != nil {
+		log.Fatalf("failed to write to DB: %s", err.Error())
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		log.Fatalf("no rowid returned")
+	}
+	return rowid
+}
+
+func openTestDb(path string) *sql.DB {
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
 		log.Fatalf("failed to open test database: %s", err.Error())
 	}
+	// Workers share the database, a single connection serializes their writes
+	db.SetMaxOpenConns(1)
 
 	_, err = db.Exec(`
 		CREATE TABLE IF NOT EXISTS TestResults(
 			config JSON,
 			agreement BOOL,
 			spec BOOL,
-			liveness BOOL);
+			liveness BOOL,
+			run_dir TEXT,
+			validity BOOL,
+			integrity BOOL,
+			new_coverage INT);
 		CREATE TABLE IF NOT EXISTS SpecLogs(
 			test_id INT,
 			log TEXT);
+		CREATE TABLE IF NOT EXISTS SpecViolations(
+			test_id INT,
+			node TEXT,
+			height INT,
+			round INT,
+			evidence JSON);
 	`)
 	if err != nil {
 		log.Fatalf("failed to create test database: %s", err.Error())
 	}
+	addColumn(db, "run_dir", "TEXT")
+	addColumn(db, "validity", "BOOL")
+	addColumn(db, "integrity", "BOOL")
+	addColumn(db, "new_coverage", "INT")
 
 	return db
 }
 
-func addTestResult(db *sql.DB, instance byzzfuzz.ByzzFuzzInstanceConfig, result testResult) {
-	res, err := db.Exec("INSERT INTO TestResults VALUES (?, ?, ?, ?)", instance.Json(), result.agreement, result.spec, result.liveness)
+func addTestResult(db *sql.DB, instance byzzfuzz.ByzzFuzzInstanceConfig, result testResult, run *artifacts.Run) {
+	res, err := db.Exec("INSERT INTO TestResults(config, agreement, spec, liveness, run_dir, validity, integrity, new_coverage) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		instance.Json(), result.agreement, result.spec, result.liveness, run.Dir, result.validity, result.integrity, result.newCoverage)
 	if err != nil {
 		log.Fatalf("failed to write to DB: %s", err.Error())
 	}
@@ -254,58 +1309,70 @@ func addTestResult(db *sql.DB, instance byzzfuzz.ByzzFuzzInstanceConfig, result
 	}
 
 	// Add spec logs
-	specLogsB, err := os.ReadFile("spec.log")
+	specLogs := strings.Builder{}
+	err = spec.WriteLog(&specLogs, result.specEvents)
 	if err != nil {
-		log.Fatalf("failed to read spec logs")
+		log.Fatalf("failed to serialize spec logs: %s", err.Error())
 	}
-	specLogs := string(specLogsB)
-	_, err = db.Exec("INSERT INTO SpecLogs VALUES (?, ?)", rowid, specLogs)
+	_, err = db.Exec("INSERT INTO SpecLogs VALUES (?, ?)", rowid, specLogs.String())
 	if err != nil {
 		log.Fatalf("failed to write spec logs to DB: %s", err.Error())
 	}
 
+	addSpecViolations(db, rowid, result.specViolations)
+}
+
+// addSpecViolations stores the reasons the spec check of a test failed
+func addSpecViolations(db *sql.DB, rowid int64, violations []spec.Violation) {
+	for _, v := range violations {
+		evidence, err := json.Marshal(v.Evidence)
+		if err != nil {
+			log.Fatalf("failed to serialize spec violation: %s", err.Error())
+		}
+		_, err = db.Exec("INSERT INTO SpecViolations VALUES (?, ?, ?, ?, ?)", rowid, v.Node, v.Height, v.Round, string(evidence))
+		if err != nil {
+			log.Fatalf("failed to write spec violations to DB: %s", err.Error())
+		}
+	}
 }
 
-func runSingleTestCase(sysParams *common.SystemParams, testcase *testlib.TestCase) (terminate bool) {
+var newServerMtx sync.Mutex
+
+// runSingleTestCase runs a test case on a fresh cluster, with the consensus timeouts of the instance if any
+func runSingleTestCase(w *worker, sysParams *common.SystemParams, testcase *testlib.TestCase, timeouts []byzzfuzz.ConsensusTimeouts, run *artifacts.Run) (terminate bool) {
 	termCh := make(chan os.Signal, 1)
 	signal.Notify(termCh, os.Interrupt, syscall.SIGTERM)
 
+	// Netrix sets up a global logger for every new server, and hands it to the server
+	newServerMtx.Lock()
 	server, err := testlib.NewTestingServer(
 		&config.Config{
-			APIServerAddr: fmt.Sprintf("%s:7074", *serverBindIp),
+			APIServerAddr: w.apiServerAddr(),
 			NumReplicas:   sysParams.N,
 			LogConfig: config.LogConfig{
 				Format: "json",
-				Path:   "/tmp/tendermint/log/checker.log",
+				Path:   run.CheckerLogPath(),
 				Level:  *logLevel,
 			},
 		},
 		&util.TMessageParser{},
 		[]*testlib.TestCase{testcase},
 	)
+	newServerMtx.Unlock()
 
 	if err != nil {
 		fmt.Printf("Failed to start server: %s\n", err.Error())
 		os.Exit(1)
 	}
 
-	docker.PrepDockerCompose()
-
-	// Stdout to file
-	dockerCompose := exec.Command("make", "localnet-start")
-	dockerCompose.Dir = "third_party/tendermint-pct-instrumentation"
-	stdoutFile, err := os.Create("nodes.stdout.log")
-	if err != nil {
-		log.Fatalf("Cannot create stdout file: %v", err)
-	}
-	defer stdoutFile.Close()
-	dockerCompose.Stdout = stdoutFile
-	dockerCompose.Stderr = stdoutFile
+	nodes, startDelay := newCluster(w, sysParams, timeouts, run)
+	w.nodes.set(nodes)
+	defer w.nodes.set(nil)
 
 	go func() {
-		time.Sleep(5 * time.Second)
+		time.Sleep(startDelay)
 		server.Logger.Info("Starting nodes")
-		err = dockerCompose.Start()
+		err = nodes.Start()
 		if err != nil {
 			log.Fatalf("Failed to start nodes: %v", err)
 		}
@@ -352,8 +1419,385 @@ func runSingleTestCase(sysParams *common.SystemParams, testcase *testlib.TestCas
 	server.Start()
 
 	server.Logger.Info("Stopping nodes")
-	dockerCompose.Process.Signal(syscall.SIGTERM)
-	dockerCompose.Wait()
+	nodes.Stop()
 
 	return terminate
 }
+
+// cluster runs the nodes under test
+type cluster interface {
+	Start() error
+	Stop()
+	byzzfuzz.NodeController
+}
+
+// clusterNodes lets test cases, which are created before their cluster, crash the nodes of the running cluster
+type clusterNodes struct {
+	nodes cluster
+	mtx   sync.Mutex
+}
+
+func (n *clusterNodes) set(nodes cluster) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.nodes = nodes
+}
+
+func (n *clusterNodes) get() (cluster, error) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.nodes == nil {
+		return nil, fmt.Errorf("no cluster is running")
+	}
+	return n.nodes, nil
+}
+
+func (n *clusterNodes) StopNode(replica *types.Replica) error {
+	nodes, err := n.get()
+	if err != nil {
+		return err
+	}
+	return nodes.StopNode(replica)
+}
+
+func (n *clusterNodes) StartNode(replica *types.Replica) error {
+	nodes, err := n.get()
+	if err != nil {
+		return err
+	}
+	return nodes.StartNode(replica)
+}
+
+// newCluster prepares the nodes for the selected backend, and returns how long to wait for the testing server before starting them
+func newCluster(w *worker, sysParams *common.SystemParams, timeouts []byzzfuzz.ConsensusTimeouts, run *artifacts.Run) (cluster, time.Duration) {
+	switch *backend {
+	case "sim":
+		simConfig := sim.DefaultConfig(sysParams.N, w.apiServerAddr())
+		simConfig.NodeTimeouts = make(map[int]sim.Timeouts)
+		for _, t := range timeouts {
+			simConfig.NodeTimeouts[t.Node] = sim.Timeouts{
+				Propose:        t.Propose,
+				ProposeDelta:   t.ProposeDelta,
+				Prevote:        t.Prevote,
+				PrevoteDelta:   t.PrevoteDelta,
+				Precommit:      t.Precommit,
+				PrecommitDelta: t.PrecommitDelta,
+				Commit:         t.Commit,
+			}
+		}
+		nodes, err := sim.NewCluster(simConfig)
+		if err != nil {
+			log.Fatalf("Failed to create simulated nodes: %v", err)
+		}
+		// Simulated nodes retry until the testing server is up
+		return nodes, 0
+	case "docker":
+		nodeTimeouts := make(map[int]map[string]time.Duration)
+		for _, t := range timeouts {
+			nodeTimeouts[t.Node] = t.Settings()
+		}
+		nodes, err := docker.NewLocalnet(docker.Worker(w.index), w.apiServerAddr(), run.NodesLogPath(), nodeTimeouts)
+		if err != nil {
+			log.Fatalf("Failed to prepare nodes: %v", err)
+		}
+		return nodes, 5 * time.Second
+	default:
+		log.Fatalf("Unknown backend %s", *backend)
+		return nil, 0
+	}
+}
+
+// worker runs one instance at a time on a cluster of its own, campaigns with --workers run several of them at once
+type worker struct {
+	index int
+	nodes *clusterNodes
+}
+
+func newWorker(index int) *worker {
+	return &worker{index: index, nodes: &clusterNodes{}}
+}
+
+// Runs the single instance of most subcommands, and is the first worker of campaigns
+var mainWorker = newWorker(0)
+
+// wake posts an event of the replica to the testing server of the worker, which runs the filters on it
+func (w *worker) wake(replica types.ReplicaID) {
+	body, err := json.Marshal(types.Event{
+		Replica:   replica,
+		TypeS:     byzzfuzz.WakeEvent,
+		Timestamp: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("failed to wake replica %s: %s", replica, err.Error())
+		return
+	}
+	resp, err := http.Post(fmt.Sprintf("http://%s/event", w.apiServerAddr()), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("failed to wake replica %s: %s", replica, err.Error())
+		return
+	}
+	resp.Body.Close()
+}
+
+// apiServerAddr is where the nodes of the worker find its testing server. Docker workers are told apart by
+// the IP address of the host on their network, simulated nodes of all workers run on the host itself.
+func (w *worker) apiServerAddr() string {
+	switch {
+	case *backend == "docker" && w.index > 0:
+		return fmt.Sprintf("%s:7074", docker.Worker(w.index).ServerIP())
+	case *backend == "sim":
+		return fmt.Sprintf("%s:%d", *serverBindIp, 7074+w.index)
+	default:
+		return fmt.Sprintf("%s:7074", *serverBindIp)
+	}
+}
+
+// runWorkers runs work on every worker, and waits for all of them to return
+func runWorkers(work func(w *worker)) {
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		w := mainWorker
+		if i > 0 {
+			w = newWorker(i)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			work(w)
+		}()
+	}
+	wg.Wait()
+}
+
+// Status of a submitted instance
+const (
+	instanceQueued   = "queued"
+	instanceRunning  = "running"
+	instanceFinished = "finished"
+	// Still queued when the server shut down, never run
+	instanceCancelled = "cancelled"
+)
+
+// servedInstance is an instance submitted to serve, as reported to clients
+type servedInstance struct {
+	ID     int                             `json:"id"`
+	Status string                          `json:"status"`
+	Config byzzfuzz.ByzzFuzzInstanceConfig `json:"config"`
+	Result *instanceResult                 `json:"result,omitempty"`
+
+	// Closed and replaced whenever the status changes, to wake up streaming clients. Stays closed once the
+	// instance is done.
+	changed chan struct{}
+}
+
+// done tells whether the status of the instance is final
+func (inst *servedInstance) done() bool {
+	return inst.Status == instanceFinished || inst.Status == instanceCancelled
+}
+
+// instanceResult is the outcome of a served instance, it passed if liveness holds
+type instanceResult struct {
+	Agreement      bool             `json:"agreement"`
+	Validity       bool             `json:"validity"`
+	Integrity      bool             `json:"integrity"`
+	Liveness       bool             `json:"liveness"`
+	Spec           bool             `json:"spec"`
+	SpecViolations []spec.Violation `json:"spec_violations,omitempty"`
+	// Set if the server was interrupted during the run, its outcome is not meaningful then
+	Terminated bool   `json:"terminated,omitempty"`
+	RunID      string `json:"run_id"`
+	RunDir     string `json:"run_dir"`
+}
+
+// instanceQueue holds all instances submitted to serve, and hands out the queued ones to the workers
+type instanceQueue struct {
+	instances []*servedInstance
+	queued    []*servedInstance
+	closed    bool
+
+	mtx  sync.Mutex
+	cond *sync.Cond
+}
+
+func newInstanceQueue() *instanceQueue {
+	q := &instanceQueue{}
+	q.cond = sync.NewCond(&q.mtx)
+	return q
+}
+
+func (q *instanceQueue) submit(config byzzfuzz.ByzzFuzzInstanceConfig) servedInstance {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	inst := &servedInstance{
+		ID:      len(q.instances) + 1,
+		Status:  instanceQueued,
+		Config:  config,
+		changed: make(chan struct{}),
+	}
+	q.instances = append(q.instances, inst)
+	if q.closed {
+		q.setStatus(inst, instanceCancelled)
+		return *inst
+	}
+	q.queued = append(q.queued, inst)
+	q.cond.Signal()
+	return *inst
+}
+
+// next waits for a queued instance and marks it as running. It returns false once the queue is closed.
+func (q *instanceQueue) next() (*servedInstance, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for len(q.queued) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	inst := q.queued[0]
+	q.queued = q.queued[1:]
+	q.setStatus(inst, instanceRunning)
+	return inst, true
+}
+
+func (q *instanceQueue) finish(inst *servedInstance, result testResult, run *artifacts.Run, terminated bool) {
+	runDir, err := filepath.Abs(run.Dir)
+	if err != nil {
+		runDir = run.Dir
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	inst.Result = &instanceResult{
+		Agreement:      result.agreement,
+		Validity:       result.validity,
+		Integrity:      result.integrity,
+		Liveness:       result.liveness,
+		Spec:           result.spec,
+		SpecViolations: result.specViolations,
+		Terminated:     terminated,
+		RunID:          run.ID,
+		RunDir:         runDir,
+	}
+	q.setStatus(inst, instanceFinished)
+}
+
+// setStatus must be called with the lock held
+func (q *instanceQueue) setStatus(inst *servedInstance, status string) {
+	inst.Status = status
+	close(inst.changed)
+	if !inst.done() {
+		inst.changed = make(chan struct{})
+	}
+}
+
+// close stops handing out instances, those still queued are cancelled
+func (q *instanceQueue) close() {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.closed = true
+	for _, inst := range q.queued {
+		q.setStatus(inst, instanceCancelled)
+	}
+	q.queued = nil
+	q.cond.Broadcast()
+}
+
+// get returns a copy of the instance with the given ID, and a channel that is closed once its status changes
+func (q *instanceQueue) get(id int) (servedInstance, <-chan struct{}, bool) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if id < 1 || id > len(q.instances) {
+		return servedInstance{}, nil, false
+	}
+	inst := q.instances[id-1]
+	return *inst, inst.changed, true
+}
+
+func (q *instanceQueue) list() []servedInstance {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	instances := make([]servedInstance, len(q.instances))
+	for i, inst := range q.instances {
+		instances[i] = *inst
+	}
+	return instances
+}
+
+// handler serves the API:
+//
+//	POST /instances       submits the instance config in the body, like run-instance reads it from stdin
+//	GET  /instances       lists all submitted instances
+//	GET  /instances/<id>  returns the status of an instance, and its result once finished.
+//	                      With ?stream=true, it returns a JSON line on every status change until the instance finishes
+//	                      or is cancelled.
+func (q *instanceQueue) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/instances", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJson(w, http.StatusOK, q.list())
+		case http.MethodPost:
+			config, err := byzzfuzz.InstanceFromJson(r.Body, sysParams)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid instance config: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			config.LivenessTimeout = campaignLivenessTimeout
+			inst := q.submit(config)
+			log.Printf("Queued instance %d: %s", inst.ID, config.Json())
+			writeJson(w, http.StatusCreated, inst)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc("/instances/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/instances/"))
+		if err != nil {
+			http.Error(w, "invalid instance ID", http.StatusBadRequest)
+			return
+		}
+		inst, changed, ok := q.get(id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no instance %d", id), http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("stream") != "true" {
+			writeJson(w, http.StatusOK, inst)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for {
+			if encoder.Encode(inst) != nil {
+				return
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if inst.done() {
+				return
+			}
+			select {
+			case <-changed:
+				inst, changed, _ = q.get(id)
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("failed to write response: %s", err.Error())
+	}
+}
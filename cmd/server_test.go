@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"byzzfuzz/byzzfuzz"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/netrixframework/tendermint-testing/common"
+)
+
+// campaignInstances draws instances of a fuzz-deflake campaign on every worker, like fuzzDeflake does
+func campaignInstances(perWorker int) []string {
+	corpus := newGuidedCorpus()
+	instances := newInstanceSource(newRand(), func(r *rand.Rand) byzzfuzz.ByzzFuzzInstanceConfig {
+		return corpus.next(r, byzzfuzz.AnyScope, defaultMaxSteps, func() byzzfuzz.ByzzFuzzInstanceConfig {
+			return sampleDeflakeInstance(r, byzzfuzz.AnyScope)
+		})
+	})
+	var drawn []string
+	var mtx sync.Mutex
+	runWorkers(func(w *worker) {
+		for i := 0; i < perWorker; i++ {
+			instance := instances.next()
+			mtx.Lock()
+			drawn = append(drawn, instance.Json())
+			mtx.Unlock()
+		}
+	})
+	// Workers take turns in any order
+	sort.Strings(drawn)
+	return drawn
+}
+
+func TestSeedReproducesInstancesWithSeveralWorkers(t *testing.T) {
+	sysParams = common.NewSystemParams(4)
+	seed, seedSet, nWorkers = 42, true, 2
+	*deflakeMaxDrops, *deflakeMaxCorruptions = 2, 2
+	defer func() {
+		seed, seedSet, nWorkers = 0, false, 1
+		*deflakeMaxDrops, *deflakeMaxCorruptions = -1, -1
+	}()
+
+	first, second := campaignInstances(20), campaignInstances(20)
+	if len(first) != 2*20 {
+		t.Fatalf("drew %d instances, want %d", len(first), 2*20)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("campaigns with the same seed drew different instances:\n%v\n%v", first, second)
+	}
+}
+
+func TestStreamOfQueuedInstanceEndsOnClose(t *testing.T) {
+	q := newInstanceQueue()
+	inst := q.submit(byzzfuzz.ByzzFuzzInstanceConfig{})
+	server := httptest.NewServer(q.handler())
+	defer server.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/instances/%d?stream=true", server.URL, inst.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	statuses := make(chan string)
+	go func() {
+		defer close(statuses)
+		lines := bufio.NewScanner(resp.Body)
+		for lines.Scan() {
+			var streamed servedInstance
+			if err := json.Unmarshal(lines.Bytes(), &streamed); err != nil {
+				t.Error(err)
+				return
+			}
+			statuses <- streamed.Status
+		}
+	}()
+	if status := <-statuses; status != instanceQueued {
+		t.Fatalf("streamed status %s, want %s", status, instanceQueued)
+	}
+
+	q.close()
+	timeout := time.After(5 * time.Second)
+	var last string
+	for {
+		select {
+		case status, ok := <-statuses:
+			if !ok {
+				if last != instanceCancelled {
+					t.Errorf("stream ended in status %s, want %s", last, instanceCancelled)
+				}
+				return
+			}
+			last = status
+		case <-timeout:
+			t.Fatal("stream of a queued instance did not end after close")
+		}
+	}
+}
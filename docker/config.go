@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// writeConfigs generates the node homes of the worker, and adapts the config of every node to the network of
+// the worker and to its timeouts
+func writeConfigs(w Worker, timeouts map[int]map[string]time.Duration) error {
+	for node := range timeouts {
+		if node < 0 || node >= LocalnetNodes {
+			return fmt.Errorf("timeouts for node %d, but the local net has %d nodes", node, LocalnetNodes)
+		}
+	}
+	err := generateConfigs(w)
+	if err != nil {
+		return err
+	}
+	for node := 0; node < LocalnetNodes; node++ {
+		err = writeConfig(w, node, timeouts[node])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateConfigs creates the node homes like `make localnet-start` does when they are missing.
+// The nodes run as root, thus files in the node homes are removed and written from containers as well.
+func generateConfigs(w Worker) error {
+	build, err := filepath.Abs(w.buildDir())
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(build, 0755)
+	if err != nil {
+		return err
+	}
+	rm := []string{"run", "--rm", "-v", build + ":/tendermint", "alpine", "rm", "-rf"}
+	for node := 0; node < LocalnetNodes; node++ {
+		rm = append(rm, fmt.Sprintf("/tendermint/node%d", node))
+	}
+	out, err := exec.Command("docker", rm...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove old node homes: %v: %s", err, out)
+	}
+	out, err = exec.Command("docker", "run", "--rm", "-v", build+":/tendermint:Z", "tendermint/localnode",
+		"testnet", "--config", "/etc/tendermint/config-template.toml", "--o", ".",
+		"--starting-ip-address", w.subnetPrefix()+"10.2").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to generate node homes: %v: %s", err, out)
+	}
+	return nil
+}
+
+// writeConfig points a node to the testing server of the worker, and sets consensus timeouts by their name in config.toml
+func writeConfig(w Worker, node int, timeouts map[string]time.Duration) error {
+	path := filepath.Join(w.buildDir(), fmt.Sprintf("node%d", node), "config", "config.toml")
+	config, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config of node %d: %v", node, err)
+	}
+	config = []byte(strings.ReplaceAll(string(config), Worker(0).ServerIP(), w.ServerIP()))
+
+	names := make([]string, 0, len(timeouts))
+	for name := range timeouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		config, err = setTimeout(config, name, timeouts[name])
+		if err != nil {
+			return fmt.Errorf("cannot set timeouts of node %d: %v", node, err)
+		}
+	}
+
+	build, err := filepath.Abs(w.buildDir())
+	if err != nil {
+		return err
+	}
+	cat := exec.Command("docker", "run", "--rm", "-i", "-v", build+":/tendermint", "alpine",
+		"sh", "-c", fmt.Sprintf("cat > /tendermint/node%d/config/config.toml", node))
+	cat.Stdin = bytes.NewReader(config)
+	out, err := cat.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("cannot write config of node %d: %v: %s", node, err, out)
+	}
+	return nil
+}
+
+// setTimeout replaces the value of a timeout. Depending on the Tendermint version, config keys use - or _.
+func setTimeout(config []byte, name string, d time.Duration) ([]byte, error) {
+	key := regexp.QuoteMeta(name)
+	key = regexp.MustCompile(`[-_]`).ReplaceAllString(key, "[-_]")
+	setting := regexp.MustCompile(`(?m)^(` + key + `)\s*=.*$`)
+	if !setting.Match(config) {
+		return nil, fmt.Errorf("no %s setting in config.toml", name)
+	}
+	return setting.ReplaceAll(config, []byte(fmt.Sprintf(`${1} = "%s"`, d))), nil
+}
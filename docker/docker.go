@@ -1,22 +1,225 @@
 package docker
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/netrixframework/netrix/types"
 )
 
-func PrepDockerCompose() {
+const tendermintDir = "third_party/tendermint-pct-instrumentation"
+
+// How long a node that starts may take to register with the testing server
+const registrationTimeout = time.Minute
+
+func PrepDockerCompose(w Worker) {
 	localNetStop := exec.Command("make", "localnet-stop")
-	localNetStop.Dir = "third_party/tendermint-pct-instrumentation"
+	localNetStop.Dir = tendermintDir
+	if w > 0 {
+		err := w.writeComposeFile()
+		if err != nil {
+			log.Fatalf("Failed to prepare network: %v", err)
+		}
+		localNetStop = w.compose("down")
+	}
 	err := localNetStop.Run()
 	if err != nil {
 		log.Fatalf("Failed to stop previous local net: %v", err)
 	}
 
-	dockerComposeUpNoStart := exec.Command("docker-compose", "up", "--no-start")
-	dockerComposeUpNoStart.Dir = "third_party/tendermint-pct-instrumentation"
+	dockerComposeUpNoStart := w.compose("up", "--no-start")
 	err = dockerComposeUpNoStart.Run()
 	if err != nil {
 		log.Fatalf("Failed to prepare network: %v", err)
 	}
 }
+
+// Localnet is the cluster of modified Tendermint nodes started by `make localnet-start`
+type Localnet struct {
+	worker Worker
+	cmds   []*exec.Cmd
+	stdout *os.File
+
+	// Testing server the replicas register with, set when nodes have timeouts of their own
+	serverAddr string
+
+	// Container of every replica, by the IP address the replica registered with
+	containers map[string]string
+	mtx        sync.Mutex
+}
+
+// NewLocalnet prepares a fresh local net of the worker, the output of the nodes is written to stdoutPath.
+// timeouts sets consensus timeouts of single nodes, by node index and name in config.toml.
+// Node indices of test cases follow the order in which replicas register with the testing server at serverAddr,
+// thus nodes with timeouts start one at a time.
+func NewLocalnet(w Worker, serverAddr string, stdoutPath string, timeouts map[int]map[string]time.Duration) (*Localnet, error) {
+	PrepDockerCompose(w)
+
+	stdoutFile, err := os.Create(stdoutPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create stdout file: %v", err)
+	}
+	l := &Localnet{worker: w, stdout: stdoutFile, containers: make(map[string]string)}
+	cmd := exec.Command("make", "localnet-start")
+	cmd.Dir = tendermintDir
+	if w > 0 || len(timeouts) > 0 {
+		err = writeConfigs(w, timeouts)
+		if err != nil {
+			stdoutFile.Close()
+			return nil, err
+		}
+		// localnet-start may generate the node homes again
+		cmd = w.compose("up")
+	}
+	if len(timeouts) > 0 {
+		l.serverAddr = serverAddr
+		for node := 0; node < LocalnetNodes; node++ {
+			l.cmds = append(l.cmds, w.compose("up", "--no-deps", "--no-recreate", fmt.Sprintf("node%d", node)))
+		}
+	} else {
+		l.cmds = []*exec.Cmd{cmd}
+	}
+	for _, cmd := range l.cmds {
+		cmd.Stdout = stdoutFile
+		cmd.Stderr = stdoutFile
+	}
+	return l, nil
+}
+
+func (l *Localnet) Start() error {
+	for node, cmd := range l.cmds {
+		err := cmd.Start()
+		if err != nil {
+			return err
+		}
+		if l.serverAddr == "" {
+			continue
+		}
+		// The node home of the container holds the timeouts of the node index the replica registers as
+		replica, err := l.awaitRegistration(node)
+		if err != nil {
+			return err
+		}
+		container, err := l.container(replica)
+		if err != nil {
+			return err
+		}
+		if container != l.worker.container(node) {
+			return fmt.Errorf("replica %s registered as node %d from container %s, but the timeouts of node %d are set in %s",
+				replica.ID, node, container, node, l.worker.container(node))
+		}
+	}
+	return nil
+}
+
+func (l *Localnet) Stop() {
+	for _, cmd := range l.cmds {
+		if cmd.Process != nil {
+			cmd.Process.Signal(syscall.SIGTERM)
+			cmd.Wait()
+		}
+	}
+	l.stdout.Close()
+}
+
+// awaitRegistration polls the testing server until the replica with the given index in the registration order registered
+func (l *Localnet) awaitRegistration(index int) (*types.Replica, error) {
+	deadline := time.Now().Add(registrationTimeout)
+	for time.Now().Before(deadline) {
+		replicas, err := l.registered()
+		if err == nil && len(replicas) > index {
+			return replicas[index], nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("node %d did not register with the testing server within %s", index, registrationTimeout)
+}
+
+// registered returns the replicas that registered with the testing server, in the order they registered
+func (l *Localnet) registered() ([]*types.Replica, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s/replicas", l.serverAddr))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Replicas []*types.Replica `json:"replicas"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+	// Slots of replicas that did not register yet are null
+	replicas := make([]*types.Replica, 0, len(body.Replicas))
+	for _, replica := range body.Replicas {
+		if replica != nil {
+			replicas = append(replicas, replica)
+		}
+	}
+	return replicas, nil
+}
+
+// StopNode kills the container of the replica, without giving Tendermint a chance to shut down cleanly
+func (l *Localnet) StopNode(replica *types.Replica) error {
+	container, err := l.container(replica)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "kill", container).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to kill %s: %v: %s", container, err, out)
+	}
+	return nil
+}
+
+// StartNode starts the container of the replica again, Tendermint then recovers from its WAL
+func (l *Localnet) StartNode(replica *types.Replica) error {
+	container, err := l.container(replica)
+	if err != nil {
+		return err
+	}
+	out, err := exec.Command("docker", "start", container).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %v: %s", container, err, out)
+	}
+	return nil
+}
+
+// container finds the container of a replica by its IP address.
+// Replicas register in any order, so their IDs do not tell the container apart.
+// Stopped containers have no address, thus the mapping is cached while all containers run.
+func (l *Localnet) container(replica *types.Replica) (string, error) {
+	host, _, err := net.SplitHostPort(replica.Addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %s of replica %s: %v", replica.Addr, replica.ID, err)
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if container, ok := l.containers[host]; ok {
+		return container, nil
+	}
+	for _, container := range l.worker.containers() {
+		out, err := exec.Command("docker", "inspect", "-f",
+			"{{range .NetworkSettings.Networks}}{{.IPAddress}}{{end}}", container).Output()
+		if err != nil {
+			continue
+		}
+		if ip := strings.TrimSpace(string(out)); ip != "" {
+			l.containers[ip] = container
+		}
+	}
+	container, ok := l.containers[host]
+	if !ok {
+		return "", fmt.Errorf("no container with address %s of replica %s", host, replica.ID)
+	}
+	return container, nil
+}
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Worker isolates the local net of one of several workers that run at the same time.
+// Worker 0 runs the local net of the instrumented Tendermint as is. Other workers get a compose project,
+// a subnet, node homes and container names of their own, derived from the files of worker 0.
+type Worker int
+
+// Second byte of the subnet of worker 0, 192.167.0.0/16, see the docker-compose file and third_party.sh.
+// Other workers count down, to stay clear of 192.168.0.0/16.
+const baseSubnet = 167
+
+// LocalnetNodes is the number of nodes of the docker-compose file of the instrumented Tendermint
+const LocalnetNodes = 4
+
+func (w Worker) subnetPrefix() string {
+	return fmt.Sprintf("192.%d.", baseSubnet-int(w))
+}
+
+// ServerIP is the address of the host on the network of the worker, where its nodes expect the testing server
+func (w Worker) ServerIP() string {
+	return w.subnetPrefix() + "0.1"
+}
+
+func (w Worker) project() string {
+	return fmt.Sprintf("byzzfuzz-worker%d", w)
+}
+
+func (w Worker) composeFile() string {
+	if w == 0 {
+		return "docker-compose.yml"
+	}
+	return fmt.Sprintf("docker-compose.worker%d.yml", w)
+}
+
+// buildDir holds the node homes, mounted into the containers by the docker-compose file
+func (w Worker) buildDir() string {
+	if w == 0 {
+		return filepath.Join(tendermintDir, "build")
+	}
+	return filepath.Join(tendermintDir, fmt.Sprintf("build-worker%d", w))
+}
+
+// container is the name of the container of a node, node homes and the docker-compose services use the same index
+func (w Worker) container(node int) string {
+	if w > 0 {
+		return fmt.Sprintf("worker%d-node%d", w, node)
+	}
+	return fmt.Sprintf("node%d", node)
+}
+
+func (w Worker) containers() []string {
+	containers := make([]string, LocalnetNodes)
+	for i := range containers {
+		containers[i] = w.container(i)
+	}
+	return containers
+}
+
+var containerName = regexp.MustCompile(`container_name: node(\d+)`)
+
+// compose runs docker-compose on the project of the worker
+func (w Worker) compose(args ...string) *exec.Cmd {
+	if w > 0 {
+		args = append([]string{"-p", w.project(), "-f", w.composeFile()}, args...)
+	}
+	cmd := exec.Command("docker-compose", args...)
+	cmd.Dir = tendermintDir
+	return cmd
+}
+
+// writeComposeFile derives the docker-compose file of the worker from the one of worker 0
+func (w Worker) writeComposeFile() error {
+	if w == 0 {
+		return nil
+	}
+	compose, err := os.ReadFile(filepath.Join(tendermintDir, Worker(0).composeFile()))
+	if err != nil {
+		return fmt.Errorf("cannot read docker-compose file: %v", err)
+	}
+	s := strings.ReplaceAll(string(compose), Worker(0).subnetPrefix(), w.subnetPrefix())
+	s = strings.ReplaceAll(s, "./build:", "./"+filepath.Base(w.buildDir())+":")
+	s = containerName.ReplaceAllStringFunc(s, func(name string) string {
+		node, _ := strconv.Atoi(containerName.FindStringSubmatch(name)[1])
+		return "container_name: " + w.container(node)
+	})
+	err = os.WriteFile(filepath.Join(tendermintDir, w.composeFile()), []byte(s), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write docker-compose file: %v", err)
+	}
+	return nil
+}
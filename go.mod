@@ -5,6 +5,8 @@ go 1.18
 require (
 	github.com/netrixframework/netrix v0.1.2
 	github.com/netrixframework/tendermint-testing v0.0.0-20220512091222-ef1204186965
+	github.com/tendermint/tendermint v0.34.10
+	modernc.org/sqlite v1.17.3
 )
 
 require (
@@ -51,7 +53,6 @@ require (
 	github.com/sirupsen/logrus v1.8.1 // indirect
 	github.com/syndtr/goleveldb v1.0.1-0.20200815110645-5c35d600f0ca // indirect
 	github.com/tecbot/gorocksdb v0.0.0-20191217155057-f0fad39f321c // indirect
-	github.com/tendermint/tendermint v0.34.10 // indirect
 	github.com/tendermint/tm-db v0.6.4 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
 	go.etcd.io/bbolt v1.3.5 // indirect
@@ -73,7 +74,6 @@ require (
 	modernc.org/mathutil v1.4.1 // indirect
 	modernc.org/memory v1.1.1 // indirect
 	modernc.org/opt v0.1.1 // indirect
-	modernc.org/sqlite v1.17.3 // indirect
 	modernc.org/strutil v1.1.1 // indirect
 	modernc.org/token v1.0.0 // indirect
 )
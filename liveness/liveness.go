@@ -18,12 +18,19 @@ func SetupLivenessTimer(timeout time.Duration) common.SetupOption {
 		go func() {
 			ctx.Logger().Info("Waiting for timeout to expire")
 			time.Sleep(timeout)
-			ctx.Logger().Info("Test finished, checking liveness")
-			ctx.Vars.Set(testFinishedKey, true)
+			ctx.Logger().Info(TestFinishedMessage)
+			MarkTestFinished(ctx)
 		}()
 	}
 }
 
+// Logged once the liveness timer expires, replays of the log of a run mark the test finished there
+const TestFinishedMessage = "Test finished, checking liveness"
+
+func MarkTestFinished(ctx *testlib.Context) {
+	ctx.Vars.Set(testFinishedKey, true)
+}
+
 func IsTestFinished(e *types.Event, ctx *testlib.Context) bool {
 	r, ok := ctx.Vars.GetBool(testFinishedKey)
 	if !ok {
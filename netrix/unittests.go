@@ -77,7 +77,7 @@ func runTestCase(sp *common.SystemParams, testcase *testlib.TestCase) bool {
 		fmt.Printf("Failed to start server: %s\n", err.Error())
 		os.Exit(1)
 	}
-	docker.PrepDockerCompose()
+	docker.PrepDockerCompose(0)
 	// Stdout to file
 	dockerCompose := exec.Command("make", "localnet-start")
 	dockerCompose.Dir = "third_party/tendermint-pct-instrumentation"
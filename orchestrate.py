@@ -11,9 +11,15 @@ import random
 import sqlite3
 import argparse
 import time
+import urllib.request
 from pathlib import Path
 
+# URL of a running `serve` subcommand, if instances should be submitted to it
+SERVER = None
+
 def run_instance(config, liveness_timeout="1m"):
+    if SERVER is not None:
+        return run_instance_on_server(config)
     proc = subprocess.Popen(["go", "run", "./cmd/server.go", "run-instance", f"--liveness-timeout={liveness_timeout}"], stdin=subprocess.PIPE, stderr=subprocess.PIPE)
     js = json.dumps(dataclasses.asdict(config))
     proc.stdin.write(bytes(js, "utf-8"))
@@ -39,6 +45,31 @@ def run_instance(config, liveness_timeout="1m"):
             print(f"WARN: cannot decode line '{line}'")
     return events
 
+def run_instance_on_server(config):
+    # The liveness timeout is set when starting the server
+    js = json.dumps(dataclasses.asdict(config))
+    req = urllib.request.Request(f"{SERVER}/instances", data=bytes(js, "utf-8"), method="POST")
+    with urllib.request.urlopen(req) as resp:
+        instance = json.load(resp)
+    print(f"Submitted instance {instance['id']}")
+
+    with urllib.request.urlopen(f"{SERVER}/instances/{instance['id']}?stream=true") as resp:
+        for line in resp:
+            instance = json.loads(line)
+            print(f"Instance {instance['id']} {instance['status']}")
+    result = instance["result"]
+
+    # Same events as run-instance writes to stderr, for check_ok and dump_events
+    events = []
+    with open(Path(result["run_dir"]) / "checker.log") as checker_log:
+        for line in checker_log:
+            try:
+                events.append(json.loads(line))
+            except json.decoder.JSONDecodeError:
+                print(f"WARN: cannot decode line '{line}'")
+    events.append({"msg": "Testcase succeeded" if result["liveness"] else "Testcase failed"})
+    return events
+
 def random_config(scope, nrof_drops=1, nrof_corruptions=0):
     drops = sorted(random.sample(ALL_DROPS, nrof_drops))
 
@@ -250,6 +281,7 @@ def quick_tests(args):
 
 if __name__ == "__main__":
     parser = argparse.ArgumentParser()
+    parser.add_argument("--server", help="URL of a running `server.go serve` to submit instances to, instead of starting run-instance for every instance")
     subparsers = parser.add_subparsers()
     subparsers.required = True
     subparsers.dest = "commmand"
@@ -282,4 +314,5 @@ if __name__ == "__main__":
     parser_quick_tests.set_defaults(func=quick_tests)
 
     args = parser.parse_args()
+    SERVER = args.server
     args.func(args)
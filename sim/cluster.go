@@ -0,0 +1,180 @@
+// Package sim runs a cluster of simulated Tendermint replicas in-process.
+// The replicas speak the Netrix replica-client protocol, so that ByzzFuzz instances can run
+// against them in seconds, without Docker or a modified Tendermint image.
+package sim
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/netrixframework/netrix/types"
+	"github.com/tendermint/tendermint/crypto"
+	"github.com/tendermint/tendermint/crypto/ed25519"
+)
+
+type Config struct {
+	N int
+	// Address of the Netrix testing server
+	APIServerAddr string
+
+	TimeoutPropose   time.Duration
+	TimeoutPrevote   time.Duration
+	TimeoutPrecommit time.Duration
+	// Increase of the propose, prevote and precommit timeouts per round
+	TimeoutDelta  time.Duration
+	TimeoutCommit time.Duration
+	// Time without progress after which a replica sends its messages again, like the gossip routines of Tendermint do
+	GossipInterval time.Duration
+	// Time between registering with the testing server and starting consensus, to let the test case set up
+	StartDelay time.Duration
+	// Timeouts of single replicas, by index, that differ from the timeouts above
+	NodeTimeouts map[int]Timeouts
+}
+
+// Timeouts of a replica. Zero durations in NodeTimeouts fall back to the timeouts of the Config.
+type Timeouts struct {
+	Propose        time.Duration
+	ProposeDelta   time.Duration
+	Prevote        time.Duration
+	PrevoteDelta   time.Duration
+	Precommit      time.Duration
+	PrecommitDelta time.Duration
+	Commit         time.Duration
+}
+
+func (c *Config) timeouts(index int) Timeouts {
+	timeouts := Timeouts{
+		Propose:        c.TimeoutPropose,
+		ProposeDelta:   c.TimeoutDelta,
+		Prevote:        c.TimeoutPrevote,
+		PrevoteDelta:   c.TimeoutDelta,
+		Precommit:      c.TimeoutPrecommit,
+		PrecommitDelta: c.TimeoutDelta,
+		Commit:         c.TimeoutCommit,
+	}
+	override := c.NodeTimeouts[index]
+	for _, t := range []struct{ value, override *time.Duration }{
+		{&timeouts.Propose, &override.Propose},
+		{&timeouts.ProposeDelta, &override.ProposeDelta},
+		{&timeouts.Prevote, &override.Prevote},
+		{&timeouts.PrevoteDelta, &override.PrevoteDelta},
+		{&timeouts.Precommit, &override.Precommit},
+		{&timeouts.PrecommitDelta, &override.PrecommitDelta},
+		{&timeouts.Commit, &override.Commit},
+	} {
+		if *t.override > 0 {
+			*t.value = *t.override
+		}
+	}
+	return timeouts
+}
+
+// DefaultConfig uses the Tendermint default timeouts.
+// Every message passes through the testing server, so much shorter timeouts make rounds expire before votes arrive.
+func DefaultConfig(n int, apiServerAddr string) Config {
+	return Config{
+		N:                n,
+		APIServerAddr:    apiServerAddr,
+		TimeoutPropose:   3 * time.Second,
+		TimeoutPrevote:   1 * time.Second,
+		TimeoutPrecommit: 1 * time.Second,
+		TimeoutDelta:     500 * time.Millisecond,
+		TimeoutCommit:    1 * time.Second,
+		GossipInterval:   2 * time.Second,
+		StartDelay:       500 * time.Millisecond,
+	}
+}
+
+type Cluster struct {
+	config   Config
+	chainID  string
+	replicas []*replica
+}
+
+func NewCluster(config Config) (*Cluster, error) {
+	c := &Cluster{
+		config:  config,
+		chainID: "byzzfuzz-sim",
+	}
+	for i := 0; i < config.N; i++ {
+		// Deterministic keys, so that runs are comparable
+		privKey := ed25519.GenPrivKeyFromSecret([]byte(fmt.Sprintf("byzzfuzz-sim-node%d", i)))
+		r, err := newReplica(i, privKey, config.APIServerAddr)
+		if err != nil {
+			c.Stop()
+			return nil, err
+		}
+		r.consensus = newConsensus(c, r)
+		c.replicas = append(c.replicas, r)
+	}
+	return c, nil
+}
+
+// Start registers the replicas with the testing server and starts consensus.
+// Replicas register in order, so that node i is the i-th replica known to the testing server.
+func (c *Cluster) Start() error {
+	for _, r := range c.replicas {
+		r.start()
+	}
+	for _, r := range c.replicas {
+		err := r.register(c.chainID)
+		if err != nil {
+			return fmt.Errorf("failed to register %s: %s", r.id, err)
+		}
+	}
+	time.Sleep(c.config.StartDelay)
+	for _, r := range c.replicas {
+		r.consensus.start()
+	}
+	return nil
+}
+
+func (c *Cluster) Stop() {
+	for _, r := range c.replicas {
+		r.consensus.stop()
+		r.stop()
+	}
+}
+
+// StopNode crashes a replica, which ignores all messages and timeouts until it is started again
+func (c *Cluster) StopNode(replica *types.Replica) error {
+	r, err := c.replica(replica.ID)
+	if err != nil {
+		return err
+	}
+	r.consensus.stop()
+	return nil
+}
+
+// StartNode restarts a crashed replica in the round it crashed in.
+// Its consensus state survives the crash, like Tendermint restores it from its WAL.
+func (c *Cluster) StartNode(replica *types.Replica) error {
+	r, err := c.replica(replica.ID)
+	if err != nil {
+		return err
+	}
+	r.consensus.start()
+	return nil
+}
+
+func (c *Cluster) replica(id types.ReplicaID) (*replica, error) {
+	for _, r := range c.replicas {
+		if r.id == id {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("no simulated replica %s", id)
+}
+
+func (c *Cluster) f() int {
+	return (c.config.N - 1) / 3
+}
+
+// proposer uses a round robin over the replicas, like Tendermint does for validators of equal voting power
+func (c *Cluster) proposer(height int, round int) int {
+	return (height + round) % c.config.N
+}
+
+func (c *Cluster) pubKey(index int) crypto.PubKey {
+	return c.replicas[index].privKey.PubKey()
+}
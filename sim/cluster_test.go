@@ -0,0 +1,148 @@
+package sim_test
+
+import (
+	"byzzfuzz/byzzfuzz"
+	"byzzfuzz/sim"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/netrixframework/netrix/config"
+	"github.com/netrixframework/netrix/testlib"
+	"github.com/netrixframework/tendermint-testing/common"
+	"github.com/netrixframework/tendermint-testing/util"
+)
+
+const testNodes = 4
+
+// Set in the processes that run a single instance
+const instanceEnv = "BYZZFUZZ_SIM_TEST_INSTANCE"
+
+type instance struct {
+	name        string
+	drops       []byzzfuzz.MessageDrop
+	corruptions []byzzfuzz.MessageCorruption
+	faulty      []int
+	want        string
+}
+
+var instances = []instance{
+	{
+		name: "clean run",
+		want: testlib.SuccessStateLabel,
+	},
+	{
+		// node0 misses the prevotes of the first round, and decides from the precommits of the others
+		name:  "dropped prevotes",
+		drops: []byzzfuzz.MessageDrop{{Step: 1, Partition: byzzfuzz.Partition{{0}, {1, 2, 3}}}},
+		want:  testlib.SuccessStateLabel,
+	},
+	{
+		// node1 proposes first. With node0, more faulty nodes than tolerated send another block to node3, which
+		// commits it while the other nodes commit the original block.
+		name: "too many equivocating nodes",
+		corruptions: []byzzfuzz.MessageCorruption{
+			{Step: 0, From: 1, To: []int{3}, Corruption: byzzfuzz.EquivocateProposal},
+			{Step: 1, From: 0, To: []int{3}, Corruption: byzzfuzz.EquivocateVote},
+			{Step: 1, From: 1, To: []int{3}, Corruption: byzzfuzz.EquivocateVote},
+			{Step: 2, From: 0, To: []int{3}, Corruption: byzzfuzz.EquivocateVote},
+			{Step: 2, From: 1, To: []int{3}, Corruption: byzzfuzz.EquivocateVote},
+		},
+		faulty: []int{0, 1},
+		want:   byzzfuzz.DiffCommitsLabel,
+	},
+}
+
+var stateLine = regexp.MustCompile(`(?m)^state: (\S+)$`)
+
+// TestInstances runs every instance in a process of its own. Netrix leaves the goroutines of a testing server
+// busy after it stops, and they slow down the instances that run after it in the same process.
+func TestInstances(t *testing.T) {
+	if os.Getenv(instanceEnv) != "" {
+		t.Skip("runs in the parent process")
+	}
+	if testing.Short() {
+		t.Skip("runs take more than a minute")
+	}
+	for i, inst := range instances {
+		t.Run(inst.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestInstance$")
+			cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", instanceEnv, i))
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%s\n%s", err, out)
+			}
+			match := stateLine.FindSubmatch(out)
+			if match == nil {
+				t.Fatalf("no final state in the output:\n%s", out)
+			}
+			if label := string(match[1]); label != inst.want {
+				t.Errorf("run ended in %s, want %s", label, inst.want)
+			}
+		})
+	}
+}
+
+// TestInstance runs the instance that TestInstances asks for, and prints the state its oracles end in
+func TestInstance(t *testing.T) {
+	index, err := strconv.Atoi(os.Getenv(instanceEnv))
+	if err != nil {
+		t.Skip("run by TestInstances")
+	}
+	inst := instances[index]
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sp := common.NewSystemParams(testNodes)
+	testcase, _ := byzzfuzz.ByzzFuzzInst(byzzfuzz.InstanceOptions{
+		SysParams:       sp,
+		Drops:           inst.drops,
+		Corruptions:     inst.corruptions,
+		Faulty:          inst.faulty,
+		Timeout:         10 * time.Second,
+		LivenessTimeout: 30 * time.Second,
+	})
+	server, err := testlib.NewTestingServer(
+		&config.Config{
+			APIServerAddr: addr,
+			NumReplicas:   testNodes,
+			LogConfig: config.LogConfig{
+				Format: "json",
+				Path:   filepath.Join(t.TempDir(), "checker.log"),
+				Level:  "info",
+			},
+		},
+		&util.TMessageParser{},
+		[]*testlib.TestCase{testcase},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, err := sim.NewCluster(sim.DefaultConfig(testNodes, addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		if err := cluster.Start(); err != nil {
+			t.Error(err)
+		}
+	}()
+	go func() {
+		<-server.Done()
+		server.Stop()
+	}()
+	server.Start()
+	cluster.Stop()
+	fmt.Printf("state: %s\n", testcase.StateMachine.CurState().Label)
+}
@@ -0,0 +1,524 @@
+package sim
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/netrixframework/tendermint-testing/util"
+	tmsg "github.com/tendermint/tendermint/proto/tendermint/consensus"
+	tmproto "github.com/tendermint/tendermint/proto/tendermint/types"
+	ttypes "github.com/tendermint/tendermint/types"
+)
+
+// Channels of the Tendermint consensus reactor, util.TMessageParser only parses messages on these channels
+const (
+	stateChannel = uint16(0x20)
+	dataChannel  = uint16(0x21)
+	voteChannel  = uint16(0x22)
+)
+
+const maxGossipBackoff = 4
+
+type step int
+
+const (
+	stepPropose step = iota
+	stepPrevote
+	stepPrecommit
+	stepCommit
+)
+
+func (s step) String() string {
+	switch s {
+	case stepPropose:
+		return "RoundStepPropose"
+	case stepPrevote:
+		return "RoundStepPrevote"
+	case stepPrecommit:
+		return "RoundStepPrecommit"
+	case stepCommit:
+		return "RoundStepCommit"
+	default:
+		panic("impossible")
+	}
+}
+
+type proposal struct {
+	blockID  ttypes.BlockID
+	polRound int
+}
+
+// consensus implements the Tendermint consensus algorithm as described in
+// "The latest gossip on BFT consensus" (Buchman, Kwon and Milosevic, 2018).
+// Blocks carry no transactions, and proposals are sent as a single message rather than in block parts.
+type consensus struct {
+	cluster  *Cluster
+	replica  *replica
+	timeouts Timeouts
+
+	running bool
+	// Incremented on every reset, so that timeouts scheduled before it are ignored
+	epoch int
+
+	height int
+	round  int
+	step   step
+
+	lockedValue string
+	lockedRound int
+	validValue  string
+	validRound  int
+
+	proposals  map[int]proposal
+	prevotes   map[int]map[int]string
+	precommits map[int]map[int]string
+	// Replicas that sent any message for a round, for the f+1 round skip rule
+	senders map[int]map[int]bool
+	// Rules that must only fire once per round
+	fired map[string]bool
+	// Block IDs by the key used in the vote sets
+	blockIDs map[string]ttypes.BlockID
+	// Messages for future heights, processed once the height is reached
+	future []*util.TMessage
+
+	// Own messages of the current height by round, and of the decided round of the previous height,
+	// sent again when the replica makes no progress
+	sent       map[int][]*util.TMessage
+	lastCommit []*util.TMessage
+	// Incremented on every step change
+	progress int
+
+	mtx sync.Mutex
+}
+
+func newConsensus(cluster *Cluster, replica *replica) *consensus {
+	c := &consensus{cluster: cluster, replica: replica, timeouts: cluster.config.timeouts(replica.index)}
+	c.reset()
+	return c
+}
+
+func (c *consensus) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.epoch++
+	c.height = 1
+	c.future = nil
+	c.lastCommit = nil
+	c.resetHeight()
+}
+
+func (c *consensus) resetHeight() {
+	c.round = 0
+	c.step = stepPropose
+	c.lockedValue = ""
+	c.lockedRound = -1
+	c.validValue = ""
+	c.validRound = -1
+	c.proposals = make(map[int]proposal)
+	c.prevotes = make(map[int]map[int]string)
+	c.precommits = make(map[int]map[int]string)
+	c.senders = make(map[int]map[int]bool)
+	c.fired = make(map[string]bool)
+	c.blockIDs = make(map[string]ttypes.BlockID)
+	c.sent = make(map[int][]*util.TMessage)
+}
+
+func (c *consensus) start() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.running {
+		return
+	}
+	c.running = true
+	c.startRound(c.round)
+}
+
+func (c *consensus) stop() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.running = false
+	c.epoch++
+}
+
+func (c *consensus) receive(m *util.TMessage) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.running {
+		return
+	}
+	c.handle(m)
+	c.evaluate()
+}
+
+// handle records a message in the state of the current height
+func (c *consensus) handle(m *util.TMessage) {
+	height, round := m.HeightRound()
+	if height > c.height {
+		c.future = append(c.future, m)
+		return
+	}
+	if height < c.height || round < 0 {
+		return
+	}
+
+	switch m.Type {
+	case util.Proposal:
+		prop, err := ttypes.ProposalFromProto(&m.Data.GetProposal().Proposal)
+		if err != nil {
+			return
+		}
+		proposer := c.cluster.proposer(height, round)
+		signBytes := ttypes.ProposalSignBytes(c.cluster.chainID, prop.ToProto())
+		if !c.cluster.pubKey(proposer).VerifySignature(signBytes, prop.Signature) {
+			return
+		}
+		if _, ok := c.proposals[round]; !ok {
+			c.proposals[round] = proposal{blockID: prop.BlockID, polRound: int(prop.POLRound)}
+		}
+		c.addSender(round, proposer)
+	case util.Prevote, util.Precommit:
+		vote, err := ttypes.VoteFromProto(m.Data.GetVote().Vote)
+		if err != nil {
+			return
+		}
+		validator := int(vote.ValidatorIndex)
+		if validator < 0 || validator >= c.cluster.config.N {
+			return
+		}
+		if vote.Verify(c.cluster.chainID, c.cluster.pubKey(validator)) != nil {
+			return
+		}
+		c.addVote(vote, validator)
+	}
+}
+
+func (c *consensus) addSender(round int, validator int) {
+	if c.senders[round] == nil {
+		c.senders[round] = make(map[int]bool)
+	}
+	c.senders[round][validator] = true
+}
+
+func (c *consensus) addVote(vote *ttypes.Vote, validator int) {
+	votes := c.prevotes
+	if vote.Type == tmproto.PrecommitType {
+		votes = c.precommits
+	}
+	round := int(vote.Round)
+	if votes[round] == nil {
+		votes[round] = make(map[int]string)
+	}
+	if _, ok := votes[round][validator]; ok {
+		// Only the first vote of a validator counts
+		return
+	}
+	key := c.blockKey(vote.BlockID)
+	votes[round][validator] = key
+	c.addSender(round, validator)
+}
+
+func (c *consensus) blockKey(blockID ttypes.BlockID) string {
+	if len(blockID.Hash) == 0 {
+		return ""
+	}
+	key := blockID.Hash.String()
+	c.blockIDs[key] = blockID
+	return key
+}
+
+// evaluate applies the rules of the algorithm until none of them applies
+func (c *consensus) evaluate() {
+	for c.evaluateOnce() {
+	}
+}
+
+func (c *consensus) evaluateOnce() bool {
+	if c.step == stepCommit {
+		// Waiting for the commit timeout before moving to the next height
+		return false
+	}
+	f := c.cluster.f()
+	quorum := 2*f + 1
+
+	// Decide in any round, as soon as a proposal is backed by a quorum of precommits
+	for round, prop := range c.proposals {
+		key := c.blockKey(prop.blockID)
+		if key != "" && count(c.precommits[round], key) >= quorum {
+			c.commit(round, key)
+			return true
+		}
+	}
+
+	// Skip to a higher round once f+1 replicas are in it
+	for round, senders := range c.senders {
+		if round > c.round && len(senders) >= f+1 {
+			c.startRound(round)
+			return true
+		}
+	}
+
+	prop, hasProposal := c.proposals[c.round]
+	propKey := ""
+	if hasProposal {
+		propKey = c.blockKey(prop.blockID)
+	}
+
+	if c.step == stepPropose && hasProposal {
+		if prop.polRound == -1 {
+			if propKey != "" && (c.lockedRound == -1 || c.lockedValue == propKey) {
+				c.prevote(propKey)
+			} else {
+				c.prevote("")
+			}
+			return true
+		}
+		if prop.polRound >= 0 && prop.polRound < c.round && count(c.prevotes[prop.polRound], propKey) >= quorum {
+			if propKey != "" && (c.lockedRound <= prop.polRound || c.lockedValue == propKey) {
+				c.prevote(propKey)
+			} else {
+				c.prevote("")
+			}
+			return true
+		}
+	}
+
+	if c.step == stepPrevote && len(c.prevotes[c.round]) >= quorum && c.once("prevote-timeout") {
+		c.schedule(c.timeouts.Prevote, c.timeouts.PrevoteDelta, c.round, c.onTimeoutPrevote)
+	}
+
+	if c.step >= stepPrevote && propKey != "" && count(c.prevotes[c.round], propKey) >= quorum && c.once("polka") {
+		if c.step == stepPrevote {
+			c.lockedValue = propKey
+			c.lockedRound = c.round
+			c.precommit(propKey)
+		}
+		c.validValue = propKey
+		c.validRound = c.round
+		return true
+	}
+
+	if c.step == stepPrevote && count(c.prevotes[c.round], "") >= quorum {
+		c.precommit("")
+		return true
+	}
+
+	if len(c.precommits[c.round]) >= quorum && c.once("precommit-timeout") {
+		c.schedule(c.timeouts.Precommit, c.timeouts.PrecommitDelta, c.round, c.onTimeoutPrecommit)
+	}
+
+	return false
+}
+
+func (c *consensus) once(rule string) bool {
+	key := fmt.Sprintf("%s/%d", rule, c.round)
+	if c.fired[key] {
+		return false
+	}
+	c.fired[key] = true
+	return true
+}
+
+func count(votes map[int]string, key string) int {
+	n := 0
+	for _, v := range votes {
+		if v == key {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *consensus) startRound(round int) {
+	c.round = round
+	c.setStep(stepPropose)
+	if c.cluster.proposer(c.height, round) == c.replica.index {
+		value := c.validValue
+		if value == "" {
+			value = c.blockKey(c.newBlockID(round))
+		}
+		c.propose(c.blockIDs[value], c.validRound)
+	}
+	c.schedule(c.timeouts.Propose, c.timeouts.ProposeDelta, round, c.onTimeoutPropose)
+}
+
+func (c *consensus) newBlockID(round int) ttypes.BlockID {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%d/%d", c.cluster.chainID, c.height, round, c.replica.index)))
+	partsHash := sha256.Sum256(hash[:])
+	return ttypes.BlockID{
+		Hash:          hash[:],
+		PartSetHeader: ttypes.PartSetHeader{Total: 1, Hash: partsHash[:]},
+	}
+}
+
+func (c *consensus) setStep(s step) {
+	c.step = s
+	c.replica.sendEvent("newStep", map[string]string{
+		"height": strconv.Itoa(c.height),
+		"round":  strconv.Itoa(c.round),
+		"step":   s.String(),
+	})
+	c.progress++
+	c.scheduleGossip(c.cluster.config.GossipInterval)
+}
+
+// scheduleGossip sends the own messages of the current round again if the replica is stuck in the current step.
+// Messages can get lost, and without this the replica might wait forever.
+// The interval doubles for as long as the replica is stuck, up to maxGossipBackoff times the configured interval,
+// to not flood the testing server.
+func (c *consensus) scheduleGossip(interval time.Duration) {
+	progress := c.progress
+	epoch := c.epoch
+	time.AfterFunc(interval, func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if !c.running || c.epoch != epoch || c.progress != progress {
+			return
+		}
+		messages := append(append([]*util.TMessage{}, c.lastCommit...), c.sent[c.round]...)
+		for _, m := range messages {
+			c.sendToOthers(m)
+		}
+		if interval < maxGossipBackoff*c.cluster.config.GossipInterval {
+			interval *= 2
+		}
+		c.scheduleGossip(interval)
+	})
+}
+
+func (c *consensus) commit(round int, key string) {
+	c.setStep(stepCommit)
+	c.replica.sendEvent("Committing block", map[string]string{
+		"height":   strconv.Itoa(c.height),
+		"round":    strconv.Itoa(round),
+		"block_id": key,
+	})
+
+	height := c.height
+	epoch := c.epoch
+	time.AfterFunc(c.timeouts.Commit, func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if !c.running || c.epoch != epoch || c.height != height {
+			return
+		}
+		c.height++
+		c.lastCommit = c.sent[round]
+		c.resetHeight()
+		c.startRound(0)
+		future := c.future
+		c.future = nil
+		for _, m := range future {
+			c.handle(m)
+		}
+		c.evaluate()
+	})
+}
+
+// schedule runs the timeout handler after the timeout for the round, if the replica is still at the same height.
+// Like in Tendermint, timeouts grow with the round.
+func (c *consensus) schedule(timeout time.Duration, delta time.Duration, round int, handler func(height int, round int)) {
+	height := c.height
+	epoch := c.epoch
+	timeout += time.Duration(round) * delta
+	time.AfterFunc(timeout, func() {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if !c.running || c.epoch != epoch || c.height != height {
+			return
+		}
+		handler(height, round)
+		c.evaluate()
+	})
+}
+
+func (c *consensus) onTimeoutPropose(height int, round int) {
+	if c.round == round && c.step == stepPropose {
+		c.prevote("")
+	}
+}
+
+func (c *consensus) onTimeoutPrevote(height int, round int) {
+	if c.round == round && c.step == stepPrevote {
+		c.precommit("")
+	}
+}
+
+func (c *consensus) onTimeoutPrecommit(height int, round int) {
+	if c.round == round && c.step != stepCommit {
+		c.startRound(round + 1)
+	}
+}
+
+func (c *consensus) propose(blockID ttypes.BlockID, polRound int) {
+	prop := ttypes.NewProposal(int64(c.height), int32(c.round), int32(polRound), blockID)
+	pp := prop.ToProto()
+	sig, err := c.replica.privKey.Sign(ttypes.ProposalSignBytes(c.cluster.chainID, pp))
+	if err != nil {
+		return
+	}
+	pp.Signature = sig
+	c.broadcast(dataChannel, util.Proposal, &tmsg.Message{
+		Sum: &tmsg.Message_Proposal{Proposal: &tmsg.Proposal{Proposal: *pp}},
+	})
+}
+
+func (c *consensus) prevote(key string) {
+	c.setStep(stepPrevote)
+	c.vote(tmproto.PrevoteType, util.Prevote, key)
+}
+
+func (c *consensus) precommit(key string) {
+	c.setStep(stepPrecommit)
+	c.vote(tmproto.PrecommitType, util.Precommit, key)
+}
+
+func (c *consensus) vote(voteType tmproto.SignedMsgType, msgType util.MessageType, key string) {
+	vote := &ttypes.Vote{
+		Type:             voteType,
+		Height:           int64(c.height),
+		Round:            int32(c.round),
+		BlockID:          c.blockIDs[key],
+		Timestamp:        time.Now(),
+		ValidatorAddress: c.replica.privKey.PubKey().Address(),
+		ValidatorIndex:   int32(c.replica.index),
+	}
+	vp := vote.ToProto()
+	sig, err := c.replica.privKey.Sign(ttypes.VoteSignBytes(c.cluster.chainID, vp))
+	if err != nil {
+		return
+	}
+	vp.Signature = sig
+	c.broadcast(voteChannel, msgType, &tmsg.Message{
+		Sum: &tmsg.Message_Vote{Vote: &tmsg.Vote{Vote: vp}},
+	})
+}
+
+// broadcast sends a message to all other replicas through the testing server, and delivers it locally right away
+func (c *consensus) broadcast(channel uint16, msgType util.MessageType, data *tmsg.Message) {
+	m := &util.TMessage{
+		ChannelID: channel,
+		From:      c.replica.id,
+		To:        c.replica.id,
+		Type:      msgType,
+		Data:      data,
+	}
+	c.sent[c.round] = append(c.sent[c.round], m)
+	c.sendToOthers(m)
+	c.handle(m)
+}
+
+func (c *consensus) sendToOthers(m *util.TMessage) {
+	for _, other := range c.cluster.replicas {
+		if other == c.replica {
+			continue
+		}
+		c.replica.sendMessage(other.id, &util.TMessage{
+			ChannelID: m.ChannelID,
+			Type:      m.Type,
+			Data:      m.Data,
+		})
+	}
+}
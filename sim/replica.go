@@ -0,0 +1,250 @@
+package sim
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/netrixframework/netrix/types"
+	"github.com/netrixframework/tendermint-testing/util"
+	"github.com/tendermint/tendermint/crypto"
+	tmjson "github.com/tendermint/tendermint/libs/json"
+	"github.com/tendermint/tendermint/privval"
+)
+
+// Time to keep retrying registration, the testing server may still be starting
+const registerTimeout = 10 * time.Second
+
+// replica is the part of a simulated node that speaks the Netrix replica-client protocol.
+// It registers with the testing server, reports messages and events, and accepts the
+// messages and directives that the testing server dispatches.
+type replica struct {
+	id        types.ReplicaID
+	index     int
+	privKey   crypto.PrivKey
+	serverURL string
+
+	listener net.Listener
+	server   *http.Server
+	client   *http.Client
+
+	// Requests to the testing server are sent in order by a single goroutine,
+	// so that a message always arrives before the event that refers to it
+	outbox chan request
+	done   chan struct{}
+
+	msgCounter int
+	mtx        sync.Mutex
+
+	consensus *consensus
+}
+
+type request struct {
+	path string
+	body []byte
+}
+
+// eventRequest is the body of a request to the /event route of the testing server
+type eventRequest struct {
+	Replica   types.ReplicaID   `json:"replica"`
+	Type      string            `json:"type"`
+	Params    map[string]string `json:"params"`
+	Timestamp int64             `json:"timestamp"`
+}
+
+type directiveRequest struct {
+	Action string `json:"action"`
+}
+
+func newReplica(index int, privKey crypto.PrivKey, serverAddr string) (*replica, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for node%d: %s", index, err)
+	}
+	r := &replica{
+		id:        types.ReplicaID(fmt.Sprintf("node%d", index)),
+		index:     index,
+		privKey:   privKey,
+		serverURL: "http://" + serverAddr,
+		listener:  listener,
+		client:    &http.Client{Timeout: 5 * time.Second},
+		outbox:    make(chan request, 10000),
+		done:      make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/message", r.handleMessage)
+	mux.HandleFunc("/directive", r.handleDirective)
+	mux.HandleFunc("/timeout", r.handleTimeout)
+	r.server = &http.Server{Handler: mux}
+	return r, nil
+}
+
+func (r *replica) start() {
+	go r.server.Serve(r.listener)
+	go r.sendLoop()
+}
+
+func (r *replica) stop() {
+	close(r.done)
+	r.server.Close()
+}
+
+// register announces the replica to the testing server, including the keys that corruptions use to re-sign messages
+func (r *replica) register(chainID string) error {
+	pvKey := privval.FilePVKey{
+		Address: r.privKey.PubKey().Address(),
+		PubKey:  r.privKey.PubKey(),
+		PrivKey: r.privKey,
+	}
+	pvKeyB, err := tmjson.Marshal(pvKey)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&types.Replica{
+		ID:    r.id,
+		Ready: true,
+		Info: map[string]interface{}{
+			"privkey":  string(pvKeyB),
+			"chain_id": chainID,
+		},
+		Addr: r.listener.Addr().String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(registerTimeout)
+	for {
+		err = r.post("/replica", body)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// sendMessage reports a message to the testing server, which decides if and when it is delivered
+func (r *replica) sendMessage(to types.ReplicaID, tMsg *util.TMessage) {
+	tMsg.From = r.id
+	tMsg.To = to
+	data, err := tMsg.Marshal()
+	if err != nil {
+		log.Printf("%s: failed to marshal message: %s", r.id, err)
+		return
+	}
+
+	r.mtx.Lock()
+	r.msgCounter++
+	id := fmt.Sprintf("%s_%s_%d", r.id, to, r.msgCounter)
+	r.mtx.Unlock()
+
+	body, err := json.Marshal(&types.Message{
+		From:      r.id,
+		To:        to,
+		Data:      data,
+		Type:      string(tMsg.Type),
+		ID:        id,
+		Intercept: true,
+	})
+	if err != nil {
+		log.Printf("%s: failed to marshal message: %s", r.id, err)
+		return
+	}
+	r.enqueue("/message", body)
+	r.sendEvent("MessageSend", map[string]string{"message_id": id})
+}
+
+func (r *replica) sendEvent(eventType string, params map[string]string) {
+	body, err := json.Marshal(&eventRequest{
+		Replica:   r.id,
+		Type:      eventType,
+		Params:    params,
+		Timestamp: time.Now().UnixNano(),
+	})
+	if err != nil {
+		log.Printf("%s: failed to marshal event: %s", r.id, err)
+		return
+	}
+	r.enqueue("/event", body)
+}
+
+func (r *replica) enqueue(path string, body []byte) {
+	select {
+	case r.outbox <- request{path: path, body: body}:
+	case <-r.done:
+	}
+}
+
+func (r *replica) sendLoop() {
+	for {
+		select {
+		case req := <-r.outbox:
+			// The testing server stops before the cluster does, so failures are expected near the end of a run
+			r.post(req.path, req.body)
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *replica) post(path string, body []byte) error {
+	resp, err := r.client.Post(r.serverURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request to %s failed with status %s", path, resp.Status)
+	}
+	return nil
+}
+
+func (r *replica) handleMessage(w http.ResponseWriter, req *http.Request) {
+	var msg types.Message
+	err := json.NewDecoder(req.Body).Decode(&msg)
+	if err != nil {
+		http.Error(w, "failed to unmarshal message", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	r.sendEvent("MessageReceive", map[string]string{"message_id": msg.ID})
+	parsed, err := (&util.TMessageParser{}).Parse(msg.Data)
+	if err != nil {
+		// Corrupted beyond recognition, a real node would drop it as well
+		return
+	}
+	r.consensus.receive(parsed.(*util.TMessage))
+}
+
+func (r *replica) handleDirective(w http.ResponseWriter, req *http.Request) {
+	var directive directiveRequest
+	err := json.NewDecoder(req.Body).Decode(&directive)
+	if err != nil {
+		http.Error(w, "failed to unmarshal directive", http.StatusBadRequest)
+		return
+	}
+	switch directive.Action {
+	case "START":
+		r.consensus.start()
+	case "STOP":
+		r.consensus.stop()
+	case "RESTART":
+		r.consensus.stop()
+		r.consensus.reset()
+		r.consensus.start()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Timeouts are handled by the replicas themselves, so the testing server has no reason to end them
+func (r *replica) handleTimeout(w http.ResponseWriter, req *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}